@@ -0,0 +1,218 @@
+package compose
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/sirupsen/logrus"
+)
+
+// CollisionStrategy defines how MergeComposeFiles handles resource names claimed by several files
+type CollisionStrategy string
+
+const (
+	// CollisionFail reports collisions as a CollisionError
+	CollisionFail CollisionStrategy = "fail"
+	// CollisionParentDir extends the directory-derived prefixes of colliding files with parent
+	// directory segments ("api" becomes "services_api") until every name is unique
+	CollisionParentDir CollisionStrategy = "parent-dir"
+)
+
+// ParseCollisionStrategy converts a command-line value to a CollisionStrategy
+func ParseCollisionStrategy(value string) (CollisionStrategy, error) {
+	switch strategy := CollisionStrategy(value); strategy {
+	case CollisionFail, CollisionParentDir:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown collision strategy %q: expected %s or %s", value, CollisionFail, CollisionParentDir)
+	}
+}
+
+// ResourceCollision describes a resource name claimed by more than one compose file
+type ResourceCollision struct {
+	Kind  string   // Resource kind: service, volume, network, config or secret
+	Name  string   // Name of the resource after prefixing, as a key of the merged project or as named in docker
+	Files []string // Compose files declaring the resource
+}
+
+// CollisionError is returned when resources from different files end up with the same name
+type CollisionError struct {
+	Collisions []ResourceCollision
+}
+
+// Error lists every colliding resource with its source files
+func (e *CollisionError) Error() string {
+	var b strings.Builder
+	b.WriteString("resource name collisions after prefixing:")
+	for _, c := range e.Collisions {
+		fmt.Fprintf(&b, "\n  %s %s declared in %s", c.Kind, c.Name, strings.Join(c.Files, ", "))
+	}
+	return b.String()
+}
+
+// plannedResource is a top-level resource with the name it will have once the file is prefixed
+type plannedResource struct {
+	kind       string
+	key        string // Name declared in the file
+	name       string
+	dockerName string // Explicit name docker gives the resource, empty when named after the project
+	shareable  bool   // Kept its name on purpose (shared, external or explicitly named)
+	def        any    // Resource definition, used to accept identical shareable declarations
+}
+
+// plannedResources returns the names every resource of the file will have after prefixResourceNames
+func (cf *ComposeFile) plannedResources(prefix string) []plannedResource {
+	var planned []plannedResource
+	add := func(kind, key, name string, external types.External, def any) {
+		if _, keep := keepResourceName(cf.Project.Name, key, name, external); keep {
+			planned = append(planned, plannedResource{kind: kind, key: key, name: key, dockerName: name, shareable: true, def: def})
+			return
+		}
+		if kind == "network" && cf.sharedNetworks[key] {
+			planned = append(planned, plannedResource{kind: kind, key: key, name: key, shareable: true, def: def})
+			return
+		}
+		planned = append(planned, plannedResource{kind: kind, key: key, name: prefix + "_" + key, def: def})
+	}
+
+	for name, service := range cf.Project.Services {
+		planned = append(planned, plannedResource{kind: "service", key: name, name: prefix + "_" + name, def: service})
+	}
+	for name, volume := range cf.Project.Volumes {
+		add("volume", name, volume.Name, volume.External, volume)
+	}
+	for name, network := range cf.Project.Networks {
+		add("network", name, network.Name, network.External, network)
+	}
+	for name, config := range cf.Project.Configs {
+		add("config", name, config.Name, config.External, config)
+	}
+	for name, secret := range cf.Project.Secrets {
+		add("secret", name, secret.Name, secret.External, secret)
+	}
+
+	return planned
+}
+
+// detectCollisions finds resources of different files that would share a name after prefixing,
+// either as keys of the merged project or as the explicit names docker gives them. Shared networks and
+// identical declarations of external or explicitly named resources are not collisions.
+func detectCollisions(files []*ComposeFile) []ResourceCollision {
+	type claim struct {
+		file     string
+		resource plannedResource
+	}
+	type claimKey struct {
+		kind string
+		name string
+	}
+	claims := make(map[claimKey][]claim)
+
+	for _, cf := range files {
+		for _, resource := range cf.plannedResources(cf.prefix()) {
+			c := claim{file: cf.Path, resource: resource}
+			key := claimKey{kind: resource.kind, name: resource.name}
+			claims[key] = append(claims[key], c)
+			if resource.dockerName != "" && resource.dockerName != resource.name {
+				named := claimKey{kind: resource.kind, name: resource.dockerName}
+				claims[named] = append(claims[named], c)
+			}
+		}
+	}
+
+	var collisions []ResourceCollision
+	for key, list := range claims {
+		first := list[0]
+		clash := false
+		for _, c := range list[1:] {
+			if c.file == first.file {
+				continue
+			}
+			if first.resource.kind == "network" && first.resource.shareable && c.resource.shareable {
+				continue
+			}
+			if !first.resource.shareable || !c.resource.shareable || !reflect.DeepEqual(first.resource.def, c.resource.def) {
+				clash = true
+			}
+		}
+		if !clash {
+			continue
+		}
+		collision := ResourceCollision{Kind: key.kind, Name: key.name}
+		for _, c := range list {
+			collision.Files = appendUnique(collision.Files, c.file)
+		}
+		collisions = append(collisions, collision)
+	}
+
+	sortReport(collisions, func(c ResourceCollision) []string { return []string{c.Kind, c.Name} })
+
+	return collisions
+}
+
+// derivedPrefix builds a prefix from the last segments of the file's directory path
+func (cf *ComposeFile) derivedPrefix(segments int) (string, bool) {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(cf.BaseDir)), "/")
+	var names []string
+	for _, part := range parts {
+		if part != "" {
+			names = append(names, part)
+		}
+	}
+	if segments > len(names) {
+		return "", false
+	}
+	return strings.Join(names[len(names)-segments:], "_"), true
+}
+
+// disambiguatePrefixes extends the directory-derived prefixes of colliding files with parent
+// directory segments until no collisions or duplicate prefixes remain. Files with an explicit
+// prefix are never changed.
+func disambiguatePrefixes(files []*ComposeFile, logger *logrus.Entry) {
+	for {
+		// Files whose prefix or resources clash with another file
+		involved := make(map[string]bool)
+		prefixOwners := make(map[string][]*ComposeFile)
+		for _, cf := range files {
+			prefixOwners[cf.prefix()] = append(prefixOwners[cf.prefix()], cf)
+		}
+		for _, owners := range prefixOwners {
+			if len(owners) > 1 {
+				for _, cf := range owners {
+					involved[cf.Path] = true
+				}
+			}
+		}
+		for _, collision := range detectCollisions(files) {
+			for _, file := range collision.Files {
+				involved[file] = true
+			}
+		}
+
+		changed := false
+		for _, cf := range files {
+			if !involved[cf.Path] || cf.Prefix != "" || cf.Extension.Prefix != "" {
+				continue
+			}
+			segments := cf.prefixSegments
+			if segments == 0 {
+				segments = 1
+			}
+			prefix, ok := cf.derivedPrefix(segments + 1)
+			if !ok {
+				continue
+			}
+			logger.Infof("Disambiguating prefix of %s from %s to %s", cf.Path, cf.prefix(), prefix)
+			cf.prefixSegments = segments + 1
+			changed = true
+		}
+
+		// Stop once nothing clashes or no prefix can be extended any further
+		if !changed {
+			return
+		}
+	}
+}
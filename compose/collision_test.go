@@ -0,0 +1,218 @@
+package compose
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// CollisionTestSuite defines the test suite for resource name collision handling
+type CollisionTestSuite struct {
+	suite.Suite
+	tmpDir string
+}
+
+// SetupTest runs before each test
+func (suite *CollisionTestSuite) SetupTest() {
+	suite.tmpDir = suite.T().TempDir()
+}
+
+// writeComposeFile writes a compose file in the given directory below the test directory and loads it
+func (suite *CollisionTestSuite) writeComposeFile(dir, content string) *ComposeFile {
+	file := writeFile(suite.T(), suite.tmpDir, filepath.Join(dir, "docker-compose.yml"), content)
+	cf, err := NewComposeFile(file)
+	require.NoError(suite.T(), err)
+	return cf
+}
+
+// TestDetectCollisions tests that resources prefixed into the same name are reported
+func (suite *CollisionTestSuite) TestDetectCollisions() {
+	cf1 := suite.writeComposeFile("a", `
+services:
+  b_c:
+    image: nginx
+volumes:
+  b_data: {}
+`)
+	cf2 := suite.writeComposeFile("a_b", `
+services:
+  c:
+    image: nginx
+volumes:
+  data: {}
+`)
+
+	_, _, err := MergeComposeFiles([]*ComposeFile{cf1, cf2})
+	require.Error(suite.T(), err)
+
+	var collisionErr *CollisionError
+	require.True(suite.T(), errors.As(err, &collisionErr))
+	assert.Equal(suite.T(), []ResourceCollision{
+		{Kind: "service", Name: "a_b_c", Files: []string{cf1.Path, cf2.Path}},
+		{Kind: "volume", Name: "a_b_data", Files: []string{cf1.Path, cf2.Path}},
+	}, collisionErr.Collisions)
+	assert.Contains(suite.T(), err.Error(), "service a_b_c declared in "+cf1.Path+", "+cf2.Path)
+}
+
+// TestDetectCollisionsAllowsSharedResources tests that deliberately shared resources are not collisions
+func (suite *CollisionTestSuite) TestDetectCollisionsAllowsSharedResources() {
+	content := `
+services:
+  app:
+    image: nginx
+    networks: [proxy, backend]
+    volumes: [certs:/certs]
+networks:
+  proxy:
+    external: true
+  backend: {}
+volumes:
+  certs:
+    external: true
+x-qec:
+  shared_networks: [backend]
+`
+	cf1 := suite.writeComposeFile("web", content)
+	cf2 := suite.writeComposeFile("admin", content)
+
+	assert.Empty(suite.T(), detectCollisionsFor(cf1, cf2))
+
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf1, cf2})
+	require.NoError(suite.T(), err)
+	assert.Contains(suite.T(), merged.Networks, "proxy")
+	assert.Contains(suite.T(), merged.Networks, "backend")
+	assert.Contains(suite.T(), merged.Volumes, "certs")
+}
+
+// TestDetectCollisionsDifferentExternalDefinitions tests that the same key with different definitions clashes
+func (suite *CollisionTestSuite) TestDetectCollisionsDifferentExternalDefinitions() {
+	cf1 := suite.writeComposeFile("web", `
+services:
+  app:
+    image: nginx
+    volumes: [data:/data]
+volumes:
+  data:
+    name: web-data
+`)
+	cf2 := suite.writeComposeFile("db", `
+services:
+  app:
+    image: postgres
+    volumes: [data:/data]
+volumes:
+  data:
+    name: db-data
+`)
+
+	assert.Equal(suite.T(), []ResourceCollision{
+		{Kind: "volume", Name: "data", Files: []string{cf1.Path, cf2.Path}},
+	}, detectCollisionsFor(cf1, cf2))
+}
+
+// TestParentDirStrategy tests disambiguating prefixes with parent directory segments
+func (suite *CollisionTestSuite) TestParentDirStrategy() {
+	content := `
+services:
+  app:
+    image: nginx
+    volumes: [data:/data]
+volumes:
+  data: {}
+`
+	cf1 := suite.writeComposeFile(filepath.Join("services", "api"), content)
+	cf2 := suite.writeComposeFile(filepath.Join("legacy", "api"), content)
+	cf3 := suite.writeComposeFile("web", content)
+
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf1, cf2, cf3}, WithCollisionStrategy(CollisionParentDir))
+	require.NoError(suite.T(), err)
+
+	// Only the colliding files get a longer prefix
+	assert.Contains(suite.T(), merged.Services, "services_api_app")
+	assert.Contains(suite.T(), merged.Services, "legacy_api_app")
+	assert.Contains(suite.T(), merged.Services, "web_app")
+	assert.Len(suite.T(), merged.Services, 3)
+
+	// Both api stacks load as project api, yet get volumes and networks of their own, which
+	// docker compose names after the merged project
+	for _, prefix := range []string{"services_api", "legacy_api", "web"} {
+		require.Contains(suite.T(), merged.Volumes, prefix+"_data")
+		assert.Empty(suite.T(), merged.Volumes[prefix+"_data"].Name)
+		require.Contains(suite.T(), merged.Networks, prefix+"_default")
+		assert.Empty(suite.T(), merged.Networks[prefix+"_default"].Name)
+	}
+}
+
+// TestDetectCollisionsOnDockerNames tests that resources under different keys clash when docker names them alike
+func (suite *CollisionTestSuite) TestDetectCollisionsOnDockerNames() {
+	cf1 := suite.writeComposeFile("web", `
+services:
+  app:
+    image: nginx
+    volumes: [data:/data]
+volumes:
+  data:
+    name: shared_data
+`)
+	cf2 := suite.writeComposeFile("db", `
+services:
+  app:
+    image: postgres
+    volumes: [cache:/cache]
+volumes:
+  cache:
+    name: shared_data
+    driver: other
+`)
+
+	assert.Equal(suite.T(), []ResourceCollision{
+		{Kind: "volume", Name: "shared_data", Files: []string{cf1.Path, cf2.Path}},
+	}, detectCollisionsFor(cf1, cf2))
+}
+
+// TestParentDirStrategyKeepsExplicitPrefixes tests that explicit prefixes are never rewritten
+func (suite *CollisionTestSuite) TestParentDirStrategyKeepsExplicitPrefixes() {
+	content := `
+services:
+  app:
+    image: nginx
+`
+	cf1 := suite.writeComposeFile("web", content)
+	cf2 := suite.writeComposeFile("admin", content)
+	cf1.Prefix = "app"
+	cf2.Prefix = "app"
+
+	_, _, err := MergeComposeFiles([]*ComposeFile{cf1, cf2}, WithCollisionStrategy(CollisionParentDir))
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), `both resolve to prefix "app"`)
+}
+
+// TestParseCollisionStrategy tests parsing collision strategies from the command line
+func (suite *CollisionTestSuite) TestParseCollisionStrategy() {
+	strategy, err := ParseCollisionStrategy("parent-dir")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), CollisionParentDir, strategy)
+
+	_, err = ParseCollisionStrategy("rename")
+	assert.Error(suite.T(), err)
+}
+
+// detectCollisionsFor marks shared networks like MergeComposeFiles does and detects collisions
+func detectCollisionsFor(files ...*ComposeFile) []ResourceCollision {
+	for _, cf := range files {
+		cf.sharedNetworks = make(map[string]bool)
+		for name := range cf.Extension.SharedNetworks {
+			cf.sharedNetworks[name] = true
+		}
+	}
+	return detectCollisions(files)
+}
+
+// Run the test suite
+func TestCollisionTestSuite(t *testing.T) {
+	suite.Run(t, new(CollisionTestSuite))
+}
@@ -0,0 +1,135 @@
+package compose
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/dotenv"
+	"github.com/compose-spec/compose-go/v2/template"
+)
+
+// EnvSourceOS is the source of variables taken from the process environment
+const EnvSourceOS = "environment"
+
+// EnvVar is an interpolation variable with the place its value was resolved from
+type EnvVar struct {
+	Name   string
+	Value  string
+	Source string // Env file the value was read from, or EnvSourceOS
+}
+
+// resolveEnvironment resolves the interpolation variables of a stack. Later sources take
+// precedence over earlier ones: the .env file in dir, the global env files, the stack's own
+// env files, then the process environment.
+func resolveEnvironment(dir string, globalEnvFiles, envFiles []string) (map[string]EnvVar, error) {
+	var files []string
+	dotEnv := filepath.Join(dir, ".env")
+	if info, err := os.Stat(dotEnv); err == nil && !info.IsDir() {
+		files = append(files, dotEnv)
+	}
+	files = append(files, globalEnvFiles...)
+	files = append(files, envFiles...)
+
+	osEnv := make(map[string]string)
+	for _, entry := range os.Environ() {
+		if name, value, ok := strings.Cut(entry, "="); ok {
+			osEnv[name] = value
+		}
+	}
+
+	resolved := make(map[string]EnvVar)
+	for _, file := range files {
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for %s: %w", file, err)
+		}
+
+		// Values may refer to variables of the process environment and of earlier files
+		current := make(map[string]string, len(resolved)+len(osEnv))
+		for name, v := range resolved {
+			current[name] = v.Value
+		}
+		for name, value := range osEnv {
+			current[name] = value
+		}
+
+		vars, err := dotenv.GetEnvFromFile(current, []string{absFile})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read env file %s: %w", file, err)
+		}
+		for name, value := range vars {
+			resolved[name] = EnvVar{Name: name, Value: value, Source: absFile}
+		}
+	}
+
+	for name, value := range osEnv {
+		resolved[name] = EnvVar{Name: name, Value: value, Source: EnvSourceOS}
+	}
+
+	return resolved, nil
+}
+
+// envList converts resolved variables to NAME=VALUE entries
+func envList(vars map[string]EnvVar) []string {
+	list := make([]string, 0, len(vars))
+	for name, v := range vars {
+		list = append(list, name+"="+v.Value)
+	}
+	return list
+}
+
+// referencedVariables returns the names of the variables interpolated in the compose files
+func referencedVariables(sources []composeSource) map[string]bool {
+	names := make(map[string]bool)
+	for _, source := range sources {
+		for name := range template.ExtractVariables(source.model, template.DefaultPattern) {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// stackEnvironment returns the variables of a stack worth reporting: every variable read from
+// an env file, and the process environment variables the compose files refer to
+func stackEnvironment(vars map[string]EnvVar, referenced map[string]bool) []EnvVar {
+	var env []EnvVar
+	for name, v := range vars {
+		if v.Source != EnvSourceOS || referenced[name] {
+			env = append(env, v)
+		}
+	}
+	sort.Slice(env, func(i, j int) bool {
+		return env[i].Name < env[j].Name
+	})
+	return env
+}
+
+// WriteEnvironment prints the interpolation variables of every stack, included stacks
+// among them, with the source each value was resolved from
+func WriteEnvironment(w io.Writer, files []*ComposeFile) error {
+	for i, cf := range expandIncludes(files) {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s (%s):\n", cf.prefix(), cf.Path); err != nil {
+			return err
+		}
+		if len(cf.Environment) == 0 {
+			if _, err := fmt.Fprintln(w, "  no variables"); err != nil {
+				return err
+			}
+		}
+		for _, v := range cf.Environment {
+			if _, err := fmt.Fprintf(w, "  %s=%s\t# %s\n", v.Name, v.Value, v.Source); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package compose
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// EnvTestSuite defines the test suite for interpolation variables
+type EnvTestSuite struct {
+	suite.Suite
+	tmpDir string
+}
+
+// SetupTest runs before each test
+func (suite *EnvTestSuite) SetupTest() {
+	suite.tmpDir = suite.T().TempDir()
+}
+
+// TestResolveEnvironmentPrecedence tests the order in which variable sources override each other
+func (suite *EnvTestSuite) TestResolveEnvironmentPrecedence() {
+	dotEnv := writeFile(suite.T(), suite.tmpDir, filepath.Join("web", ".env"), "TAG=dotenv\nPORT=80\nREGION=eu\nQEC_TEST_USER=dotenv\n")
+	global := writeFile(suite.T(), suite.tmpDir, "global.env", "TAG=global\nPORT=8080\n")
+	stack := writeFile(suite.T(), suite.tmpDir, "web.env", "TAG=stack\nURL=http://${REGION}.example.com:${PORT}\n")
+	suite.T().Setenv("QEC_TEST_USER", "shell")
+
+	vars, err := resolveEnvironment(filepath.Join(suite.tmpDir, "web"), []string{global}, []string{stack})
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), EnvVar{Name: "TAG", Value: "stack", Source: stack}, vars["TAG"])
+	assert.Equal(suite.T(), EnvVar{Name: "PORT", Value: "8080", Source: global}, vars["PORT"])
+	assert.Equal(suite.T(), EnvVar{Name: "REGION", Value: "eu", Source: dotEnv}, vars["REGION"])
+	assert.Equal(suite.T(), EnvVar{Name: "QEC_TEST_USER", Value: "shell", Source: EnvSourceOS}, vars["QEC_TEST_USER"])
+
+	// Values can refer to variables of lower precedence files
+	assert.Equal(suite.T(), "http://eu.example.com:8080", vars["URL"].Value)
+}
+
+// TestResolveEnvironmentMissingFile tests that missing env files are reported
+func (suite *EnvTestSuite) TestResolveEnvironmentMissingFile() {
+	_, err := resolveEnvironment(suite.tmpDir, []string{filepath.Join(suite.tmpDir, "missing.env")}, nil)
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "missing.env")
+}
+
+// TestLoadStackWithEnvFiles tests that every stack is interpolated with its own variables
+func (suite *EnvTestSuite) TestLoadStackWithEnvFiles() {
+	webFile := writeFile(suite.T(), suite.tmpDir, filepath.Join("web", "docker-compose.yml"), `
+services:
+  app:
+    image: nginx:${TAG}
+    environment:
+      LEVEL: ${LOG_LEVEL:-info}
+`)
+	writeFile(suite.T(), suite.tmpDir, filepath.Join("web", ".env"), "TAG=1.25\n")
+	dbFile := writeFile(suite.T(), suite.tmpDir, filepath.Join("db", "docker-compose.yml"), `
+services:
+  app:
+    image: postgres:${TAG}
+    environment:
+      LEVEL: ${LOG_LEVEL:-info}
+`)
+	dbEnv := writeFile(suite.T(), suite.tmpDir, filepath.Join("env", "db.env"), "TAG=16\n")
+	global := writeFile(suite.T(), suite.tmpDir, filepath.Join("env", "global.env"), "LOG_LEVEL=debug\n")
+
+	web, err := LoadStack(StackFiles{Path: webFile}, []string{global})
+	require.NoError(suite.T(), err)
+	db, err := LoadStack(StackFiles{Path: dbFile, Prefix: "pg", EnvFiles: []string{dbEnv}}, []string{global})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "pg", db.Prefix)
+
+	assert.Equal(suite.T(), "nginx:1.25", web.Project.Services["app"].Image)
+	assert.Equal(suite.T(), "postgres:16", db.Project.Services["app"].Image)
+	assert.Equal(suite.T(), "debug", *db.Project.Services["app"].Environment["LEVEL"])
+
+	// Verify that the reported variables keep their sources
+	assert.Equal(suite.T(), []EnvVar{
+		{Name: "LOG_LEVEL", Value: "debug", Source: global},
+		{Name: "TAG", Value: "16", Source: dbEnv},
+	}, db.Environment)
+}
+
+// TestWriteEnvironment tests printing the variables of every stack
+func (suite *EnvTestSuite) TestWriteEnvironment() {
+	webFile := writeFile(suite.T(), suite.tmpDir, filepath.Join("web", "docker-compose.yml"), `
+services:
+  app:
+    image: nginx:${TAG}
+    environment:
+      HOME_DIR: ${QEC_TEST_HOME}
+`)
+	dotEnv := writeFile(suite.T(), suite.tmpDir, filepath.Join("web", ".env"), "TAG=1.25\n")
+	dbFile := writeFile(suite.T(), suite.tmpDir, filepath.Join("db", "docker-compose.yml"), `
+services:
+  app:
+    image: postgres
+`)
+	suite.T().Setenv("QEC_TEST_HOME", "/home/qec")
+
+	web, err := LoadStack(StackFiles{Path: webFile}, nil)
+	require.NoError(suite.T(), err)
+	db, err := LoadStack(StackFiles{Path: dbFile}, nil)
+	require.NoError(suite.T(), err)
+
+	var out bytes.Buffer
+	err = WriteEnvironment(&out, []*ComposeFile{web, db})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "web ("+webFile+"):\n"+
+		"  QEC_TEST_HOME=/home/qec\t# environment\n"+
+		"  TAG=1.25\t# "+dotEnv+"\n"+
+		"\n"+
+		"db ("+dbFile+"):\n"+
+		"  no variables\n", out.String())
+}
+
+// Run the test suite
+func TestEnvTestSuite(t *testing.T) {
+	suite.Run(t, new(EnvTestSuite))
+}
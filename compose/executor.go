@@ -11,9 +11,20 @@ import (
 
 // Executor handles Docker Compose command execution with merged configurations
 type Executor struct {
-	project    *types.Project
-	workingDir string
-	dryRun     bool
+	project       *types.Project
+	workingDir    string
+	dryRun        bool
+	relativePaths bool
+	skipValidate  bool
+	sources       map[string]string
+}
+
+// creatingCommands are the Docker Compose commands that create containers, and so are
+// validated first; others, like down, must keep working on a project with dangling references
+var creatingCommands = map[string]bool{
+	"up":     true,
+	"create": true,
+	"run":    true,
 }
 
 // NewExecutor creates a new Docker Compose executor
@@ -25,6 +36,25 @@ func NewExecutor(project *types.Project, workingDir string, dryRun bool) *Execut
 	}
 }
 
+// WithRelativePaths makes the executor write paths relative to the merged file's location,
+// so the merged file can be shared between machines
+func (e *Executor) WithRelativePaths() *Executor {
+	e.relativePaths = true
+	return e
+}
+
+// WithSources names the file each service was declared in when reporting validation problems
+func (e *Executor) WithSources(sources map[string]string) *Executor {
+	e.sources = sources
+	return e
+}
+
+// WithoutValidation makes the executor create containers without validating the project first
+func (e *Executor) WithoutValidation() *Executor {
+	e.skipValidate = true
+	return e
+}
+
 // writeConfig writes the merged configuration to a temporary file
 func (e *Executor) writeConfig() (string, error) {
 	logger := logrus.New().WithField("function", "writeConfig")
@@ -37,8 +67,21 @@ func (e *Executor) writeConfig() (string, error) {
 	// Create a temporary file for the merged configuration
 	configFile := filepath.Join(e.workingDir, "docker-compose.merged.yml")
 
+	// Express paths relative to the merged file when requested
+	project := e.project
+	if e.relativePaths {
+		relative, changes, err := relativizePaths(e.project, filepath.Dir(configFile))
+		if err != nil {
+			return "", fmt.Errorf("failed to make paths relative: %w", err)
+		}
+		for _, change := range changes {
+			logger.Debugf("Relativized path in %s", change)
+		}
+		project = relative
+	}
+
 	// Marshal the configuration to YAML
-	yaml, err := e.project.MarshalYAML()
+	yaml, err := project.MarshalYAML()
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal configuration: %w", err)
 	}
@@ -57,6 +100,13 @@ func (e *Executor) writeConfig() (string, error) {
 func (e *Executor) ExecuteCommand(cmdName string, args ...string) error {
 	logger := logrus.New().WithField("function", "ExecuteCommand")
 
+	// Catch dangling references before Docker Compose creates containers from them
+	if creatingCommands[cmdName] && !e.skipValidate {
+		if err := Validate(e.project, e.sources); err != nil {
+			return err
+		}
+	}
+
 	// First check if Docker Compose is available
 	if err := CheckDockerCompose(); err != nil {
 		return fmt.Errorf("docker compose check failed: %w", err)
@@ -74,8 +124,16 @@ func (e *Executor) ExecuteCommand(cmdName string, args ...string) error {
 		return fmt.Errorf("failed to create docker compose command: %w", err)
 	}
 
-	// Build the command arguments
-	cmdArgs := []string{"-f", configFile, cmdName}
+	// Build the command arguments, naming the project and activating the profiles of the merged services
+	cmdArgs := []string{"-f", configFile}
+	if e.project.Name != "" {
+		// Name the project explicitly so that every command targets the same containers
+		cmdArgs = append(cmdArgs, "-p", e.project.Name)
+	}
+	for _, profile := range e.project.Profiles {
+		cmdArgs = append(cmdArgs, "--profile", profile)
+	}
+	cmdArgs = append(cmdArgs, cmdName)
 	cmdArgs = append(cmdArgs, args...)
 
 	// Configure the command
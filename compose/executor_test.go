@@ -63,6 +63,40 @@ func (suite *ExecutorTestSuite) TestWriteConfig() {
 	assert.Contains(suite.T(), string(content), "hello-world")
 }
 
+// TestWriteConfigWithRelativePaths tests writing paths relative to the merged file
+func (suite *ExecutorTestSuite) TestWriteConfigWithRelativePaths() {
+	// Create a stack in a subdirectory with a build context and a bind mount
+	stackDir := filepath.Join(suite.tmpDir, "web")
+	err := os.MkdirAll(filepath.Join(stackDir, "app"), 0755)
+	require.NoError(suite.T(), err)
+	composeFile := filepath.Join(stackDir, "docker-compose.yml")
+	err = os.WriteFile(composeFile, []byte(`
+services:
+  app:
+    build: ./app
+    volumes:
+      - ./data:/data
+`), 0644)
+	require.NoError(suite.T(), err)
+
+	cf, err := NewComposeFile(composeFile)
+	require.NoError(suite.T(), err)
+
+	executor := NewExecutor(cf.Project, suite.tmpDir, false).WithRelativePaths()
+	configFile, err := executor.writeConfig()
+	require.NoError(suite.T(), err)
+
+	// Verify that the written file holds no absolute paths
+	content, err := os.ReadFile(configFile)
+	require.NoError(suite.T(), err)
+	assert.Contains(suite.T(), string(content), "context: ./web/app")
+	assert.Contains(suite.T(), string(content), "source: ./web/data")
+	assert.NotContains(suite.T(), string(content), suite.tmpDir)
+
+	// Verify that the executor's project keeps its absolute paths
+	assert.Equal(suite.T(), filepath.Join(stackDir, "app"), cf.Project.Services["app"].Build.Context)
+}
+
 // TestExecuteCommand tests the generic command execution
 func (suite *ExecutorTestSuite) TestExecuteCommand() {
 	executor := NewExecutor(suite.project, suite.tmpDir, false)
@@ -110,6 +144,31 @@ func (suite *ExecutorTestSuite) TestExecuteCommandDryRun() {
 	assert.True(suite.T(), os.IsNotExist(err))
 }
 
+// TestExecuteCommandValidation tests that containers are not created for a project with dangling references
+func (suite *ExecutorTestSuite) TestExecuteCommandValidation() {
+	service := suite.project.Services["test"]
+	service.Links = []string{"missing"}
+	suite.project.Services["test"] = service
+
+	executor := NewExecutor(suite.project, suite.tmpDir, false).
+		WithSources(map[string]string{"test": "/stacks/test/docker-compose.yml"})
+	err := executor.ExecuteCommand("up")
+	require.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "service test (/stacks/test/docker-compose.yml): links refers to undefined service missing")
+
+	// Verify that the merged config file was not written
+	_, err = os.Stat(filepath.Join(suite.tmpDir, "docker-compose.merged.yml"))
+	assert.True(suite.T(), os.IsNotExist(err))
+
+	// Verify that commands which do not create containers still run, so the stack can be torn down
+	err = executor.ExecuteCommand("down")
+	assert.NoError(suite.T(), err)
+
+	// Verify that validation can be skipped
+	err = executor.WithoutValidation().ExecuteCommand("up")
+	assert.NoError(suite.T(), err)
+}
+
 // Run the test suite
 func TestExecutorTestSuite(t *testing.T) {
 	suite.Run(t, new(ExecutorTestSuite))
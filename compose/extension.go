@@ -0,0 +1,194 @@
+package compose
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// ExtensionKey is the top-level compose extension holding qec settings
+const ExtensionKey = "x-qec"
+
+// DependsOnKey is the service extension declaring dependencies on services of other stacks
+const DependsOnKey = "x-qec-depends-on"
+
+// Extension represents the qec settings declared in a compose file under x-qec
+type Extension struct {
+	Prefix         string         `json:"prefix,omitempty"`
+	SharedNetworks SharedNetworks `json:"shared_networks,omitempty"`
+	Ports          PortSettings   `json:"ports,omitempty"`
+}
+
+// PortSettings configures how conflicting ports are moved
+type PortSettings struct {
+	Strategy string `json:"strategy,omitempty"` // Port strategy name, see ParsePortStrategy
+	Range    string `json:"range,omitempty"`    // FROM-TO ports searched by the next-free strategy
+	Base     uint32 `json:"base,omitempty"`     // Base port of the declaring stack for the stack-base strategy
+}
+
+// SharedNetwork describes a network kept unprefixed and shared across compose files
+type SharedNetwork struct {
+	// Services lists the services attached to the network, either as a service of the
+	// declaring file ("postgres") or qualified with another file's prefix ("web/app")
+	Services []string `json:"services,omitempty"`
+}
+
+// SharedNetworks maps shared network names to their configuration
+type SharedNetworks map[string]SharedNetwork
+
+// UnmarshalJSON accepts both a list of network names and a map of network configurations
+func (s *SharedNetworks) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err == nil {
+		*s = make(SharedNetworks, len(names))
+		for _, name := range names {
+			(*s)[name] = SharedNetwork{}
+		}
+		return nil
+	}
+
+	var networks map[string]*SharedNetwork
+	if err := json.Unmarshal(data, &networks); err != nil {
+		return fmt.Errorf("shared_networks must be a list of names or a map of networks: %w", err)
+	}
+	*s = make(SharedNetworks, len(networks))
+	for name, network := range networks {
+		if network == nil {
+			network = &SharedNetwork{}
+		}
+		(*s)[name] = *network
+	}
+	return nil
+}
+
+// Dependencies maps service references to dependency settings. A reference names a service of
+// another stack qualified with its prefix ("db/postgres") or a service of the declaring file.
+type Dependencies map[string]types.ServiceDependency
+
+// UnmarshalJSON accepts both a list of service references and a map of dependency settings.
+// Missing settings default to those of depends_on: condition service_started, required.
+func (d *Dependencies) UnmarshalJSON(data []byte) error {
+	var refs []string
+	if err := json.Unmarshal(data, &refs); err == nil {
+		*d = make(Dependencies, len(refs))
+		for _, ref := range refs {
+			(*d)[ref] = types.ServiceDependency{Condition: types.ServiceConditionStarted, Required: true}
+		}
+		return nil
+	}
+
+	var settings map[string]*struct {
+		Condition string `json:"condition"`
+		Restart   bool   `json:"restart"`
+		Required  *bool  `json:"required"`
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("%s must be a list of services or a map of dependencies: %w", DependsOnKey, err)
+	}
+	*d = make(Dependencies, len(settings))
+	for ref, setting := range settings {
+		dependency := types.ServiceDependency{Condition: types.ServiceConditionStarted, Required: true}
+		if setting != nil {
+			if setting.Condition != "" {
+				dependency.Condition = setting.Condition
+			}
+			dependency.Restart = setting.Restart
+			if setting.Required != nil {
+				dependency.Required = *setting.Required
+			}
+		}
+		(*d)[ref] = dependency
+	}
+	return nil
+}
+
+// parseDependencies decodes the x-qec-depends-on extension of every service and removes it from the services
+func parseDependencies(project *types.Project) (map[string]Dependencies, error) {
+	dependencies := make(map[string]Dependencies)
+
+	for name, service := range project.Services {
+		raw, ok := service.Extensions[DependsOnKey]
+		if !ok {
+			continue
+		}
+		delete(service.Extensions, DependsOnKey)
+		project.Services[name] = service
+
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s of service %s: %w", DependsOnKey, name, err)
+		}
+		var deps Dependencies
+		if err := json.Unmarshal(data, &deps); err != nil {
+			return nil, fmt.Errorf("failed to decode %s of service %s: %w", DependsOnKey, name, err)
+		}
+		dependencies[name] = deps
+	}
+
+	return dependencies, nil
+}
+
+// parseExtension decodes the x-qec extension from the project and removes it from the project extensions
+func parseExtension(project *types.Project) (Extension, error) {
+	var ext Extension
+
+	raw, ok := project.Extensions[ExtensionKey]
+	if !ok {
+		return ext, nil
+	}
+	delete(project.Extensions, ExtensionKey)
+
+	// Round-trip through JSON to decode the loosely typed YAML structure
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return ext, fmt.Errorf("failed to encode %s extension: %w", ExtensionKey, err)
+	}
+	if err := json.Unmarshal(data, &ext); err != nil {
+		return ext, fmt.Errorf("failed to decode %s extension: %w", ExtensionKey, err)
+	}
+
+	return ext, nil
+}
+
+// mergeExtensions combines the top-level x- fields of every file. A field declared with the same
+// value everywhere is kept as is; a field whose values differ is namespaced per stack, so that
+// x-defaults of the web stack becomes x-web_defaults.
+func mergeExtensions(files []*ComposeFile) types.Extensions {
+	type declaration struct {
+		prefix string
+		value  any
+	}
+	declared := make(map[string][]declaration)
+	var order []string
+	for _, cf := range files {
+		for key, value := range cf.Project.Extensions {
+			if _, ok := declared[key]; !ok {
+				order = append(order, key)
+			}
+			declared[key] = append(declared[key], declaration{prefix: cf.prefix(), value: value})
+		}
+	}
+
+	merged := make(types.Extensions, len(order))
+	for _, key := range order {
+		list := declared[key]
+		conflict := false
+		for _, d := range list[1:] {
+			if !reflect.DeepEqual(d.value, list[0].value) {
+				conflict = true
+			}
+		}
+		if !conflict {
+			merged[key] = list[0].value
+			continue
+		}
+		for _, d := range list {
+			merged["x-"+d.prefix+"_"+strings.TrimPrefix(key, "x-")] = d.value
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,137 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// ExtensionTestSuite defines the test suite for x-qec extension parsing
+type ExtensionTestSuite struct {
+	suite.Suite
+	tmpDir string
+}
+
+// SetupTest runs before each test
+func (suite *ExtensionTestSuite) SetupTest() {
+	suite.tmpDir = suite.T().TempDir()
+}
+
+// loadComposeFile writes the given content to a compose file and loads it
+func (suite *ExtensionTestSuite) loadComposeFile(content string) (*ComposeFile, error) {
+	testFile := filepath.Join(suite.tmpDir, "docker-compose.yml")
+	err := os.WriteFile(testFile, []byte(content), 0644)
+	require.NoError(suite.T(), err)
+	return NewComposeFile(testFile)
+}
+
+// TestParseSharedNetworksList tests the list form of shared_networks
+func (suite *ExtensionTestSuite) TestParseSharedNetworksList() {
+	cf, err := suite.loadComposeFile(`
+services:
+  app:
+    image: nginx
+x-qec:
+  shared_networks:
+    - backend
+    - frontend
+`)
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), SharedNetworks{
+		"backend":  {},
+		"frontend": {},
+	}, cf.Extension.SharedNetworks)
+
+	// The extension is consumed and not carried into the project
+	assert.NotContains(suite.T(), cf.Project.Extensions, ExtensionKey)
+}
+
+// TestParseSharedNetworksMap tests the map form of shared_networks
+func (suite *ExtensionTestSuite) TestParseSharedNetworksMap() {
+	cf, err := suite.loadComposeFile(`
+services:
+  app:
+    image: nginx
+x-qec:
+  shared_networks:
+    backend:
+      services: [app, db/postgres]
+    frontend:
+`)
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), SharedNetworks{
+		"backend":  {Services: []string{"app", "db/postgres"}},
+		"frontend": {},
+	}, cf.Extension.SharedNetworks)
+}
+
+// TestParseInvalidExtension tests that malformed settings are reported
+func (suite *ExtensionTestSuite) TestParseInvalidExtension() {
+	_, err := suite.loadComposeFile(`
+services:
+  app:
+    image: nginx
+x-qec:
+  shared_networks: backend
+`)
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "failed to parse x-qec settings")
+}
+
+// TestMergeExtensions tests that extension fields of every file reach the merged project
+func (suite *ExtensionTestSuite) TestMergeExtensions() {
+	write := func(dir, content string) *ComposeFile {
+		file := filepath.Join(suite.tmpDir, dir, "docker-compose.yml")
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		require.NoError(suite.T(), err)
+		err = os.WriteFile(file, []byte(content), 0644)
+		require.NoError(suite.T(), err)
+		cf, err := NewComposeFile(file)
+		require.NoError(suite.T(), err)
+		return cf
+	}
+
+	web := write("web", `
+services:
+  app:
+    image: nginx
+x-owner: platform
+x-logging:
+  driver: json-file
+x-web-only: true
+x-qec:
+  prefix: web
+`)
+	db := write("db", `
+services:
+  app:
+    image: postgres
+x-owner: platform
+x-logging:
+  driver: syslog
+`)
+
+	merged, _, err := MergeComposeFiles([]*ComposeFile{web, db})
+	require.NoError(suite.T(), err)
+
+	// Identical fields are kept once, conflicting ones are namespaced per stack
+	assert.Equal(suite.T(), "platform", merged.Extensions["x-owner"])
+	assert.Equal(suite.T(), true, merged.Extensions["x-web-only"])
+	assert.NotContains(suite.T(), merged.Extensions, "x-logging")
+	assert.Equal(suite.T(), map[string]any{"driver": "json-file"}, merged.Extensions["x-web_logging"])
+	assert.Equal(suite.T(), map[string]any{"driver": "syslog"}, merged.Extensions["x-db_logging"])
+
+	// The qec settings are not carried over
+	assert.NotContains(suite.T(), merged.Extensions, ExtensionKey)
+}
+
+// Run the test suite
+func TestExtensionTestSuite(t *testing.T) {
+	suite.Run(t, new(ExtensionTestSuite))
+}
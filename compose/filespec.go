@@ -0,0 +1,113 @@
+package compose
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// prefixPattern matches valid resource name prefixes
+var prefixPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// FileSpec describes a compose file given on the command line
+type FileSpec struct {
+	Path     string // Path to the compose file
+	Prefix   string // Prefix for the file's resources, empty to derive it
+	Override bool   // Whether the file overrides the previous stack instead of starting a new one
+}
+
+// ParseFileSpec parses a "path" or "path:prefix" command-line value
+func ParseFileSpec(value string) FileSpec {
+	if i := strings.LastIndex(value, ":"); i > 0 && prefixPattern.MatchString(value[i+1:]) {
+		return FileSpec{Path: value[:i], Prefix: value[i+1:]}
+	}
+	return FileSpec{Path: value}
+}
+
+// StackFiles holds a base compose file and the override files deep-merged into it
+type StackFiles struct {
+	Path      string
+	Prefix    string
+	Overrides []string
+	EnvFiles  []string // Env files used for interpolation of the stack's files
+}
+
+// validatePrefix checks that a prefix can be used in resource names
+func validatePrefix(prefix string) error {
+	if !prefixPattern.MatchString(prefix) {
+		return fmt.Errorf("invalid prefix %q: must start with a letter or digit and contain only letters, digits, '_', '.' and '-'", prefix)
+	}
+	return nil
+}
+
+// declaredPrefix returns the prefix a compose file declares under x-qec. Files that cannot be
+// read or parsed declare none here; loading them reports the error.
+func declaredPrefix(path string) string {
+	sources, err := readSources([]string{path})
+	if err != nil {
+		return ""
+	}
+	settings, _ := sources[0].model[ExtensionKey].(map[string]any)
+	prefix, _ := settings["prefix"].(string)
+	return prefix
+}
+
+// GroupFileSpecs groups compose files into stacks. A file joins an earlier stack as an override
+// when it lives in the same directory as that stack's base file, or when it is marked as an
+// override, in which case it joins the stack given just before it. A file with its own prefix,
+// given on the command line or declared under x-qec, always starts a new stack unless the prefix
+// matches the stack it would join.
+func GroupFileSpecs(specs []FileSpec) ([]StackFiles, error) {
+	var stacks []StackFiles
+	var prefixes []string // Prefix of each stack, given or declared by its base file
+	byDir := make(map[string][]int)
+
+	for _, spec := range specs {
+		absPath, err := filepath.Abs(spec.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for %s: %w", spec.Path, err)
+		}
+		dir := filepath.Dir(absPath)
+
+		if spec.Prefix != "" {
+			if err := validatePrefix(spec.Prefix); err != nil {
+				return nil, fmt.Errorf("compose file %s: %w", spec.Path, err)
+			}
+		}
+
+		if spec.Override {
+			if spec.Prefix != "" {
+				return nil, fmt.Errorf("override file %s cannot set a prefix", spec.Path)
+			}
+			if len(stacks) == 0 {
+				return nil, fmt.Errorf("override file %s must follow a compose file", spec.Path)
+			}
+			last := &stacks[len(stacks)-1]
+			last.Overrides = append(last.Overrides, spec.Path)
+			continue
+		}
+
+		prefix := spec.Prefix
+		if prefix == "" {
+			prefix = declaredPrefix(absPath)
+		}
+		joined := false
+		for _, i := range byDir[dir] {
+			if prefix == "" || prefix == prefixes[i] {
+				stacks[i].Overrides = append(stacks[i].Overrides, spec.Path)
+				joined = true
+				break
+			}
+		}
+		if joined {
+			continue
+		}
+
+		byDir[dir] = append(byDir[dir], len(stacks))
+		stacks = append(stacks, StackFiles{Path: spec.Path, Prefix: spec.Prefix})
+		prefixes = append(prefixes, prefix)
+	}
+
+	return stacks, nil
+}
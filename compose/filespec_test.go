@@ -0,0 +1,179 @@
+package compose
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// FileSpecTestSuite defines the test suite for compose file grouping
+type FileSpecTestSuite struct {
+	suite.Suite
+}
+
+// TestGroupFileSpecs tests grouping compose files into stacks
+func (suite *FileSpecTestSuite) TestGroupFileSpecs() {
+	tests := []struct {
+		name  string
+		specs []FileSpec
+		want  []StackFiles
+	}{
+		{
+			name: "separate directories",
+			specs: []FileSpec{
+				{Path: "web/docker-compose.yml"},
+				{Path: "db/docker-compose.yml"},
+			},
+			want: []StackFiles{
+				{Path: "web/docker-compose.yml"},
+				{Path: "db/docker-compose.yml"},
+			},
+		},
+		{
+			name: "same directory is merged",
+			specs: []FileSpec{
+				{Path: "web/docker-compose.yml"},
+				{Path: "db/docker-compose.yml"},
+				{Path: "web/docker-compose.override.yml"},
+			},
+			want: []StackFiles{
+				{Path: "web/docker-compose.yml", Overrides: []string{"web/docker-compose.override.yml"}},
+				{Path: "db/docker-compose.yml"},
+			},
+		},
+		{
+			name: "explicit override joins previous stack",
+			specs: []FileSpec{
+				{Path: "web/docker-compose.yml"},
+				{Path: "db/docker-compose.yml"},
+				{Path: "shared/db-debug.yml", Override: true},
+			},
+			want: []StackFiles{
+				{Path: "web/docker-compose.yml"},
+				{Path: "db/docker-compose.yml", Overrides: []string{"shared/db-debug.yml"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			got, err := GroupFileSpecs(tt.specs)
+			require.NoError(suite.T(), err)
+			assert.Equal(suite.T(), tt.want, got)
+		})
+	}
+}
+
+// TestParseFileSpec tests parsing of compose file command-line values
+func (suite *FileSpecTestSuite) TestParseFileSpec() {
+	tests := []struct {
+		value string
+		want  FileSpec
+	}{
+		{value: "web/docker-compose.yml", want: FileSpec{Path: "web/docker-compose.yml"}},
+		{value: "web/docker-compose.yml:frontend", want: FileSpec{Path: "web/docker-compose.yml", Prefix: "frontend"}},
+		{value: "./compose/docker-compose.yml:my_app.v2", want: FileSpec{Path: "./compose/docker-compose.yml", Prefix: "my_app.v2"}},
+		{value: "dir:with/colon/docker-compose.yml", want: FileSpec{Path: "dir:with/colon/docker-compose.yml"}},
+		{value: `C:\stacks\docker-compose.yml`, want: FileSpec{Path: `C:\stacks\docker-compose.yml`}},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.value, func() {
+			assert.Equal(suite.T(), tt.want, ParseFileSpec(tt.value))
+		})
+	}
+}
+
+// TestGroupFileSpecsWithPrefixes tests that prefixes decide whether same-directory files are merged
+func (suite *FileSpecTestSuite) TestGroupFileSpecsWithPrefixes() {
+	got, err := GroupFileSpecs([]FileSpec{
+		{Path: "stacks/docker-compose.yml", Prefix: "web"},
+		{Path: "stacks/docker-compose.override.yml", Prefix: "web"},
+		{Path: "stacks/db.yml", Prefix: "db"},
+	})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []StackFiles{
+		{Path: "stacks/docker-compose.yml", Prefix: "web", Overrides: []string{"stacks/docker-compose.override.yml"}},
+		{Path: "stacks/db.yml", Prefix: "db"},
+	}, got)
+
+	// Invalid prefixes are rejected
+	_, err = GroupFileSpecs([]FileSpec{{Path: "web/docker-compose.yml", Prefix: "-web"}})
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "invalid prefix")
+
+	// Override files cannot set a prefix
+	_, err = GroupFileSpecs([]FileSpec{
+		{Path: "web/docker-compose.yml"},
+		{Path: "shared/debug.yml", Prefix: "debug", Override: true},
+	})
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "cannot set a prefix")
+}
+
+// TestGroupFileSpecsWithDeclaredPrefixes tests that files declaring their own x-qec prefix are not
+// merged into another stack of the same directory
+func (suite *FileSpecTestSuite) TestGroupFileSpecsWithDeclaredPrefixes() {
+	dir := suite.T().TempDir()
+	web := writeFile(suite.T(), dir, filepath.Join("compose", "web.yml"), `
+services:
+  app:
+    image: nginx
+x-qec:
+  prefix: web
+`)
+	db := writeFile(suite.T(), dir, filepath.Join("compose", "db.yml"), `
+services:
+  app:
+    image: postgres
+x-qec:
+  prefix: db
+`)
+	debug := writeFile(suite.T(), dir, filepath.Join("compose", "db.debug.yml"), `
+services:
+  app:
+    environment:
+      DEBUG: "1"
+x-qec:
+  prefix: db
+`)
+	override := writeFile(suite.T(), dir, filepath.Join("compose", "override.yml"), `
+services:
+  app:
+    restart: always
+`)
+
+	stacks, err := GroupFileSpecs([]FileSpec{{Path: web}, {Path: db}, {Path: debug}, {Path: override}})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []StackFiles{
+		{Path: web, Overrides: []string{override}},
+		{Path: db, Overrides: []string{debug}},
+	}, stacks)
+
+	// Both stacks are merged, with none of their services lost
+	var files []*ComposeFile
+	for _, stack := range stacks {
+		cf, err := NewComposeFile(stack.Path, stack.Overrides...)
+		require.NoError(suite.T(), err)
+		files = append(files, cf)
+	}
+	merged, _, err := MergeComposeFiles(files)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "nginx", merged.Services["web_app"].Image)
+	assert.Equal(suite.T(), "postgres", merged.Services["db_app"].Image)
+}
+
+// TestGroupFileSpecsOverrideFirst tests that an override needs a stack to extend
+func (suite *FileSpecTestSuite) TestGroupFileSpecsOverrideFirst() {
+	_, err := GroupFileSpecs([]FileSpec{{Path: filepath.Join("web", "override.yml"), Override: true}})
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "must follow a compose file")
+}
+
+// Run the test suite
+func TestFileSpecTestSuite(t *testing.T) {
+	suite.Run(t, new(FileSpecTestSuite))
+}
@@ -0,0 +1,168 @@
+package compose
+
+import (
+	"fmt"
+	"path/filepath"
+
+	interp "github.com/compose-spec/compose-go/v2/interpolation"
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultNetwork is the network compose attaches services to when they declare none
+const defaultNetwork = "default"
+
+// includeEntry is an entry of the compose include directive with paths made absolute
+type includeEntry struct {
+	paths            []string // Included file followed by the files merged into it
+	projectDirectory string   // Directory relative paths of the included files are resolved against
+	envFiles         []string // Env files used for interpolation of the included files
+}
+
+// readIncludes reads the include directive of a compose file, interpolated with the stack's
+// variables. Relative paths are resolved against the directory of the including file, as compose does.
+func readIncludes(source composeSource, vars map[string]EnvVar) ([]includeEntry, error) {
+	path := source.path
+	if source.model["include"] == nil {
+		return nil, nil
+	}
+	interpolated, err := interp.Interpolate(map[string]any{"include": source.model["include"]}, interp.Options{
+		LookupValue: func(name string) (string, bool) {
+			v, ok := vars[name]
+			return v.Value, ok
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to interpolate include directive in %s: %w", path, err)
+	}
+	include, ok := interpolated["include"].([]any)
+	if !ok {
+		return nil, fmt.Errorf("include directive in %s must be a list", path)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	var entries []includeEntry
+	for _, raw := range include {
+		var entry includeEntry
+		switch v := raw.(type) {
+		case string:
+			entry.paths = []string{resolve(v)}
+		case map[string]any:
+			paths, err := stringOrList(v["path"])
+			if err != nil {
+				return nil, fmt.Errorf("invalid include path in %s: %w", path, err)
+			}
+			for _, p := range paths {
+				entry.paths = append(entry.paths, resolve(p))
+			}
+			if projectDir, ok := v["project_directory"].(string); ok {
+				entry.projectDirectory = resolve(projectDir)
+			}
+			envFiles, err := stringOrList(v["env_file"])
+			if err != nil {
+				return nil, fmt.Errorf("invalid include env_file in %s: %w", path, err)
+			}
+			for _, f := range envFiles {
+				entry.envFiles = append(entry.envFiles, resolve(f))
+			}
+		default:
+			return nil, fmt.Errorf("invalid include entry in %s: %v", path, raw)
+		}
+		if len(entry.paths) == 0 {
+			return nil, fmt.Errorf("include entry in %s has no path", path)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// stringOrList converts a YAML value holding a string or a list of strings to a slice
+func stringOrList(value any) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []any:
+		var list []string
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string, got %v", item)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("expected a string or a list of strings, got %v", value)
+	}
+}
+
+// expandIncludes returns the files followed by the stacks they include, depth first
+func expandIncludes(files []*ComposeFile) []*ComposeFile {
+	var expanded []*ComposeFile
+	for _, cf := range files {
+		expanded = append(expanded, cf)
+		expanded = append(expanded, expandIncludes(cf.Includes)...)
+	}
+	return expanded
+}
+
+// collectIncludedResources maps the resources of the stacks the file includes, directly or not,
+// by kind and original name to their merged names. Compose lets the including file refer to
+// them; resources of the file itself take precedence.
+func (cf *ComposeFile) collectIncludedResources() map[string]map[string]string {
+	names := make(map[string]map[string]string)
+	for _, included := range expandIncludes(cf.Includes) {
+		for _, resource := range included.plannedResources(included.prefix()) {
+			if names[resource.kind] == nil {
+				names[resource.kind] = make(map[string]string)
+			}
+			if _, ok := names[resource.kind][resource.key]; !ok {
+				names[resource.kind][resource.key] = resource.name
+			}
+		}
+	}
+	return names
+}
+
+// joinIncludingNetworks attaches services of included stacks that use their stack's default
+// network to the default network of every stack including them, as compose runs included
+// services on the network of the including project. They get no alias there, so services of
+// different stacks sharing a name do not clash.
+func joinIncludingNetworks(project *types.Project, files []*ComposeFile, logger *logrus.Entry) {
+	for _, cf := range files {
+		network := cf.prefix() + "_" + defaultNetwork
+		for _, included := range expandIncludes(cf.Includes) {
+			own := included.prefix() + "_" + defaultNetwork
+			for name := range included.Project.Services {
+				service, ok := project.Services[name]
+				if !ok {
+					continue
+				}
+				if _, ok := service.Networks[own]; !ok {
+					continue
+				}
+				if project.Networks == nil {
+					project.Networks = make(types.Networks)
+				}
+				if _, ok := project.Networks[network]; !ok {
+					project.Networks[network] = types.NetworkConfig{Name: network}
+				}
+				if _, ok := service.Networks[network]; !ok {
+					service.Networks[network] = nil
+					logger.Debugf("Attached included service %s to network %s", name, network)
+				}
+				project.Services[name] = service
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// IncludeTestSuite defines the test suite for the include directive
+type IncludeTestSuite struct {
+	suite.Suite
+	tmpDir string
+}
+
+// SetupTest runs before each test
+func (suite *IncludeTestSuite) SetupTest() {
+	suite.tmpDir = suite.T().TempDir()
+}
+
+// TestMergeComposeFilesWithIncludes tests that included files are merged as stacks of their own
+func (suite *IncludeTestSuite) TestMergeComposeFilesWithIncludes() {
+	topFile := writeFile(suite.T(), suite.tmpDir, filepath.Join("stack", "docker-compose.yml"), `
+include:
+  - ../web/docker-compose.yml
+  - path: ../db/docker-compose.yml
+services:
+  proxy:
+    image: traefik
+`)
+	writeFile(suite.T(), suite.tmpDir, filepath.Join("web", "docker-compose.yml"), `
+services:
+  app:
+    image: nginx
+    build: ./app
+`)
+	writeFile(suite.T(), suite.tmpDir, filepath.Join("db", "docker-compose.yml"), `
+services:
+  app:
+    image: postgres
+volumes:
+  data: {}
+`)
+	err := os.MkdirAll(filepath.Join(suite.tmpDir, "web", "app"), 0755)
+	require.NoError(suite.T(), err)
+
+	cf, err := NewComposeFile(topFile)
+	require.NoError(suite.T(), err)
+
+	// Verify that included services are not loaded into the including project
+	assert.Len(suite.T(), cf.Project.Services, 1)
+	require.Len(suite.T(), cf.Includes, 2)
+	assert.Equal(suite.T(), filepath.Join(suite.tmpDir, "web"), cf.Includes[0].BaseDir)
+	assert.Equal(suite.T(), filepath.Join(suite.tmpDir, "db"), cf.Includes[1].BaseDir)
+
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf})
+	require.NoError(suite.T(), err)
+
+	// Verify that each included file gets its own prefix
+	assert.Contains(suite.T(), merged.Services, "stack_proxy")
+	assert.Contains(suite.T(), merged.Services, "web_app")
+	assert.Contains(suite.T(), merged.Services, "db_app")
+	assert.Contains(suite.T(), merged.Volumes, "db_data")
+
+	// Verify that build contexts are resolved against the included file's directory
+	assert.Equal(suite.T(), filepath.Join(suite.tmpDir, "web", "app"), merged.Services["web_app"].Build.Context)
+}
+
+// TestIncludeWithProjectDirectory tests that project_directory sets the included stack's base directory
+func (suite *IncludeTestSuite) TestIncludeWithProjectDirectory() {
+	topFile := writeFile(suite.T(), suite.tmpDir, "docker-compose.yml", `
+include:
+  - path: compose/api.yml
+    project_directory: api
+`)
+	writeFile(suite.T(), suite.tmpDir, filepath.Join("compose", "api.yml"), `
+services:
+  server:
+    image: node
+    build: ./src
+`)
+	err := os.MkdirAll(filepath.Join(suite.tmpDir, "api", "src"), 0755)
+	require.NoError(suite.T(), err)
+
+	cf, err := NewComposeFile(topFile)
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), cf.Includes, 1)
+
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf})
+	require.NoError(suite.T(), err)
+	assert.Contains(suite.T(), merged.Services, "api_server")
+	assert.Equal(suite.T(), filepath.Join(suite.tmpDir, "api", "src"), merged.Services["api_server"].Build.Context)
+}
+
+// TestIncludeWithInterpolatedPath tests that include paths are interpolated like the rest of the file
+func (suite *IncludeTestSuite) TestIncludeWithInterpolatedPath() {
+	topFile := writeFile(suite.T(), suite.tmpDir, filepath.Join("app", "docker-compose.yml"), `
+include:
+  - ${DB_DIR:-../db}/compose.yml
+services:
+  web:
+    image: nginx
+`)
+	writeFile(suite.T(), suite.tmpDir, filepath.Join("db", "compose.yml"), `
+services:
+  postgres:
+    image: postgres
+`)
+	writeFile(suite.T(), suite.tmpDir, filepath.Join("cache", "compose.yml"), `
+services:
+  redis:
+    image: redis
+`)
+
+	// The default applies while the variable is unset
+	cf, err := NewComposeFile(topFile)
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), cf.Includes, 1)
+	assert.Equal(suite.T(), filepath.Join(suite.tmpDir, "db"), cf.Includes[0].BaseDir)
+
+	suite.T().Setenv("DB_DIR", "../cache")
+	cf, err = NewComposeFile(topFile)
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), cf.Includes, 1)
+	assert.Equal(suite.T(), filepath.Join(suite.tmpDir, "cache"), cf.Includes[0].BaseDir)
+}
+
+// TestIncludeReferences tests that references to services and resources of included stacks
+// resolve to their prefixed names
+func (suite *IncludeTestSuite) TestIncludeReferences() {
+	topFile := writeFile(suite.T(), suite.tmpDir, filepath.Join("app", "docker-compose.yml"), `
+include:
+  - ../db/docker-compose.yml
+services:
+  web:
+    image: nginx
+    depends_on: [postgres]
+    links: ["postgres:database"]
+    volumes: [pgdata:/backup:ro]
+    environment:
+      DATABASE_URL: postgres://postgres:5432/app
+`)
+	writeFile(suite.T(), suite.tmpDir, filepath.Join("db", "docker-compose.yml"), `
+services:
+  postgres:
+    image: postgres
+    volumes: [pgdata:/var/lib/postgresql/data]
+volumes:
+  pgdata: {}
+`)
+
+	cf, err := NewComposeFile(topFile)
+	require.NoError(suite.T(), err)
+
+	merged, report, err := MergeComposeFiles([]*ComposeFile{cf})
+	require.NoError(suite.T(), err)
+
+	web := merged.Services["app_web"]
+	assert.Contains(suite.T(), web.DependsOn, "db_postgres")
+	assert.Equal(suite.T(), []string{"db_postgres:database"}, web.Links)
+	assert.Equal(suite.T(), "db_pgdata", web.Volumes[0].Source)
+	assert.Equal(suite.T(), "postgres://db_postgres:5432/app", *web.Environment["DATABASE_URL"])
+
+	// Included services join the including stack's default network, as compose runs them on one network
+	postgres := merged.Services["db_postgres"]
+	assert.Contains(suite.T(), postgres.Networks, "db_default")
+	assert.Contains(suite.T(), postgres.Networks, "app_default")
+	assert.Nil(suite.T(), postgres.Networks["app_default"])
+	assert.NoError(suite.T(), Validate(merged, report.Sources))
+}
+
+// TestIncludeCycle tests that include cycles are reported
+func (suite *IncludeTestSuite) TestIncludeCycle() {
+	topFile := writeFile(suite.T(), suite.tmpDir, filepath.Join("a", "docker-compose.yml"), `
+include:
+  - ../b/docker-compose.yml
+services:
+  app:
+    image: nginx
+`)
+	writeFile(suite.T(), suite.tmpDir, filepath.Join("b", "docker-compose.yml"), `
+include:
+  - ../a/docker-compose.yml
+services:
+  app:
+    image: nginx
+`)
+
+	_, err := NewComposeFile(topFile)
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "include cycle detected")
+}
+
+// Run the test suite
+func TestIncludeTestSuite(t *testing.T) {
+	suite.Run(t, new(IncludeTestSuite))
+}
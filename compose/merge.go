@@ -4,36 +4,129 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/compose-spec/compose-go/v2/cli"
+	"github.com/compose-spec/compose-go/v2/loader"
 	"github.com/compose-spec/compose-go/v2/types"
 	"github.com/sirupsen/logrus"
 )
 
 // ComposeFile represents a Docker Compose file with its metadata
 type ComposeFile struct {
-	Path    string
-	BaseDir string
-	Project *types.Project
+	Path      string
+	BaseDir   string
+	Prefix    string // Explicit prefix for resource names, takes precedence over x-qec.prefix
+	Overrides []string
+	Project   *types.Project
+	Extension Extension
+	Includes  []*ComposeFile // Stacks pulled in with the include directive
+	// Environment holds the interpolation variables read from env files and the process
+	// environment variables the files refer to, with their sources
+	Environment []EnvVar
+
+	// sharedNetworks holds the networks kept unprefixed while merging
+	sharedNetworks map[string]bool
+	// prefixSegments is the number of directory segments in a derived prefix, set when disambiguating
+	prefixSegments int
+	// dependencies holds the x-qec-depends-on settings of the file's services
+	dependencies map[string]Dependencies
+	// serviceOrder lists the file's services in the order they are declared
+	serviceOrder []string
+	// includedResources maps the resources of the included stacks by kind and original name to
+	// their merged names, set when merging
+	includedResources map[string]map[string]string
+}
+
+// NewComposeFile creates a new ComposeFile instance. Override files are deep-merged into
+// the base file using compose-spec merge semantics, as docker compose does with several -f flags.
+// Files pulled in with the include directive are loaded as separate stacks in Includes.
+func NewComposeFile(path string, overrides ...string) (*ComposeFile, error) {
+	return loadComposeFile(path, overrides, loadOptions{})
+}
+
+// LoadStack loads the files of a stack. Interpolation variables are resolved from the stack
+// directory's .env file, the global env files, the stack's env files and the process
+// environment, each taking precedence over the previous ones.
+func LoadStack(stack StackFiles, globalEnvFiles []string) (*ComposeFile, error) {
+	cf, err := loadComposeFile(stack.Path, stack.Overrides, loadOptions{
+		globalEnvFiles: globalEnvFiles,
+		envFiles:       stack.EnvFiles,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cf.Prefix = stack.Prefix
+	return cf, nil
+}
+
+// loadOptions holds the settings used to load a compose file
+type loadOptions struct {
+	workingDir     string   // Directory relative paths are resolved against, the file's directory when empty
+	globalEnvFiles []string // Env files applied to every stack, included ones among them
+	envFiles       []string // Env files of the stack, taking precedence over the global ones
+	chain          []string // Files including this one, used to detect include cycles
 }
 
-// NewComposeFile creates a new ComposeFile instance
-func NewComposeFile(path string) (*ComposeFile, error) {
+// loadComposeFile loads a compose file with its overrides and included stacks
+func loadComposeFile(path string, overrides []string, opts loadOptions) (*ComposeFile, error) {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get absolute path for %s: %w", path, err)
 	}
 
 	baseDir := filepath.Dir(absPath)
+	if opts.workingDir != "" {
+		baseDir = opts.workingDir
+	}
 
-	// Create project options with the file's base directory
-	options, err := cli.NewProjectOptions(
-		[]string{absPath},
+	// Override files are loaded after the base file so their values take precedence
+	paths := []string{absPath}
+	var absOverrides []string
+	for _, override := range overrides {
+		absOverride, err := filepath.Abs(override)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for %s: %w", override, err)
+		}
+		absOverrides = append(absOverrides, absOverride)
+		paths = append(paths, absOverride)
+	}
+
+	// Resolve interpolation variables, remembering where each one comes from
+	vars, err := resolveEnvironment(baseDir, opts.globalEnvFiles, opts.envFiles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve environment for %s: %w", path, err)
+	}
+
+	// Parse the files for what the project does not keep: the variables they refer to,
+	// the declaration order of the services, which maps lose, and the includes
+	sources, err := readSources(paths)
+	if err != nil {
+		return nil, err
+	}
+	hasIncludes := false
+	for _, source := range sources {
+		if source.model["include"] != nil {
+			hasIncludes = true
+		}
+	}
+
+	// Create project options with the file's base directory. Includes are loaded
+	// separately so that each included file keeps its own prefix and base directory.
+	projectOpts := []cli.ProjectOptionsFn{
 		cli.WithWorkingDirectory(baseDir),
-		cli.WithOsEnv,
-		cli.WithDotEnv,
-	)
+		cli.WithEnv(envList(vars)),
+		// Every profile is loaded so that MergeComposeFiles can select profiles per stack
+		cli.WithProfiles([]string{"*"}),
+		cli.WithLoadOptions(func(o *loader.Options) {
+			o.SkipInclude = true
+			// Services may refer to services, volumes and networks of the included files,
+			// which are only resolved when merging
+			o.SkipConsistencyCheck = hasIncludes
+		}),
+	}
+	options, err := cli.NewProjectOptions(paths, projectOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create project options: %w", err)
 	}
@@ -44,69 +137,373 @@ func NewComposeFile(path string) (*ComposeFile, error) {
 		return nil, fmt.Errorf("failed to load project from %s: %w", path, err)
 	}
 
-	return &ComposeFile{
-		Path:    absPath,
-		BaseDir: baseDir,
-		Project: project,
-	}, nil
+	// Read qec settings declared in the file
+	ext, err := parseExtension(project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s settings in %s: %w", ExtensionKey, path, err)
+	}
+
+	// Read dependencies on services of other stacks
+	dependencies, err := parseDependencies(project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s in %s: %w", DependsOnKey, path, err)
+	}
+
+	cf := &ComposeFile{
+		Path:         absPath,
+		BaseDir:      baseDir,
+		Overrides:    absOverrides,
+		Project:      project,
+		Extension:    ext,
+		Environment:  stackEnvironment(vars, referencedVariables(sources)),
+		dependencies: dependencies,
+		serviceOrder: declaredServices(sources),
+	}
+
+	// Load included files as stacks of their own
+	chain := append(append([]string{}, opts.chain...), absPath)
+	for _, source := range sources {
+		file := source.path
+		includes, err := readIncludes(source, vars)
+		if err != nil {
+			return nil, err
+		}
+		for _, include := range includes {
+			for _, parent := range chain {
+				if parent == include.paths[0] {
+					return nil, fmt.Errorf("include cycle detected: %s includes %s", file, include.paths[0])
+				}
+			}
+			included, err := loadComposeFile(include.paths[0], include.paths[1:], loadOptions{
+				workingDir:     include.projectDirectory,
+				globalEnvFiles: opts.globalEnvFiles,
+				envFiles:       include.envFiles,
+				chain:          chain,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to load %s included from %s: %w", include.paths[0], file, err)
+			}
+			cf.Includes = append(cf.Includes, included)
+		}
+	}
+
+	return cf, nil
 }
 
-// adjustBuildContexts converts relative build contexts to absolute paths
-func (cf *ComposeFile) adjustBuildContexts() error {
-	logger := logrus.New().WithField("function", "adjustBuildContexts")
+// prefix returns the prefix applied to the file's resource names: the explicit prefix,
+// then the x-qec.prefix setting, then the name of the file's directory
+func (cf *ComposeFile) prefix() string {
+	if cf.Prefix != "" {
+		return cf.Prefix
+	}
+	if cf.Extension.Prefix != "" {
+		return cf.Extension.Prefix
+	}
+	if cf.prefixSegments > 1 {
+		if prefix, ok := cf.derivedPrefix(cf.prefixSegments); ok {
+			return prefix
+		}
+	}
+	return filepath.Base(cf.BaseDir)
+}
 
-	for name, service := range cf.Project.Services {
-		if service.Build == nil {
-			continue
+// MergeOption configures how compose files are merged
+type MergeOption func(*mergeOptions)
+
+// mergeOptions holds the settings applied by MergeOption functions
+type mergeOptions struct {
+	sharedNetworks    SharedNetworks
+	rewriteHostnames  bool
+	collisionStrategy CollisionStrategy
+	verbose           bool
+	profiles          []ProfileSelector
+	prefixProfiles    bool
+	projectName       string
+	portProbe         PortProbe
+	portStrategy      PortStrategy
+	portBases         map[string]uint32
+}
+
+// WithSharedNetwork keeps the named network unprefixed and attaches the given services to it.
+// Services are referenced with their file prefix, either as "web/app" or as the merged name "web_app".
+func WithSharedNetwork(name string, services ...string) MergeOption {
+	return func(o *mergeOptions) {
+		network := o.sharedNetworks[name]
+		network.Services = append(network.Services, services...)
+		o.sharedNetworks[name] = network
+	}
+}
+
+// WithCollisionStrategy sets how resource name collisions between files are handled
+func WithCollisionStrategy(strategy CollisionStrategy) MergeOption {
+	return func(o *mergeOptions) {
+		o.collisionStrategy = strategy
+	}
+}
+
+// WithoutHostnameRewrite disables rewriting of service hostnames in environment values, commands and healthchecks
+func WithoutHostnameRewrite() MergeOption {
+	return func(o *mergeOptions) {
+		o.rewriteHostnames = false
+	}
+}
+
+// WithProfiles activates compose profiles. Services whose profiles are not active in their
+// stack are left out of the merged project, as docker compose does.
+func WithProfiles(selectors ...ProfileSelector) MergeOption {
+	return func(o *mergeOptions) {
+		o.profiles = append(o.profiles, selectors...)
+	}
+}
+
+// WithPrefixedProfiles renames service profiles to <prefix>_<profile> in the merged project
+func WithPrefixedProfiles() MergeOption {
+	return func(o *mergeOptions) {
+		o.prefixProfiles = true
+	}
+}
+
+// WithProjectName sets the name of the merged project instead of the first file's project name
+func WithProjectName(name string) MergeOption {
+	return func(o *mergeOptions) {
+		o.projectName = name
+	}
+}
+
+// WithPortProbe checks ports moved to resolve conflicts with the probe, skipping those in use on the host
+func WithPortProbe(probe PortProbe) MergeOption {
+	return func(o *mergeOptions) {
+		o.portProbe = probe
+	}
+}
+
+// WithPortStrategy moves conflicting ports with the given strategy instead of the one
+// configured in the files' x-qec.ports settings
+func WithPortStrategy(strategy PortStrategy) MergeOption {
+	return func(o *mergeOptions) {
+		o.portStrategy = strategy
+	}
+}
+
+// WithPortBase sets the base port of the stack with the given prefix for the stack-base
+// strategy, taking precedence over x-qec.ports.base in the stack's file
+func WithPortBase(stack string, base uint32) MergeOption {
+	return func(o *mergeOptions) {
+		if o.portBases == nil {
+			o.portBases = make(map[string]uint32)
 		}
+		o.portBases[stack] = base
+	}
+}
+
+// WithVerbose reports every adjustment made to the files, such as each path made absolute
+func WithVerbose() MergeOption {
+	return func(o *mergeOptions) {
+		o.verbose = true
+	}
+}
+
+// prepare normalizes paths, prefixes resource names and rewrites hostnames of the file,
+// recording the changes in the report
+func (cf *ComposeFile) prepare(options *mergeOptions, report *MergeReport, logger *logrus.Entry) error {
+	// Make relative paths absolute so they survive moving the merged file
+	for _, change := range cf.normalizePaths() {
+		logger.Debugf("Normalized path in %s", change)
+		report.PathChanges[cf.Path] = append(report.PathChanges[cf.Path], change)
+	}
+
+	// Get prefix from settings or directory name
+	prefix := cf.prefix()
+	if err := cf.prefixResourceNames(prefix, logger); err != nil {
+		return fmt.Errorf("failed to prefix resource names for %s: %w", cf.Path, err)
+	}
+	if options.prefixProfiles {
+		cf.prefixProfiles(prefix)
+	}
 
-		// If context is relative, make it absolute using the file's base directory
-		if !filepath.IsAbs(service.Build.Context) {
-			absContext := filepath.Join(cf.BaseDir, service.Build.Context)
-			logger.Debugf("Converting build context for service %s from %s to %s",
-				name, service.Build.Context, absContext)
-			service.Build.Context = absContext
+	// Record where each service comes from, so problems found later can point at the file
+	for name := range cf.Project.Services {
+		report.Sources[name] = cf.Path
+	}
+
+	// Point references to the original service names at the prefixed ones
+	if options.rewriteHostnames {
+		for _, rewrite := range cf.rewriteHostnames(prefix) {
+			logger.Infof("Rewrote hostname in %s", rewrite)
+			report.HostnameRewrites = append(report.HostnameRewrites, rewrite)
 		}
 	}
+
 	return nil
 }
 
-// MergeComposeFiles merges multiple compose files
-func MergeComposeFiles(files []*ComposeFile) (*types.Project, error) {
+// MergeReport describes how the compose files were changed to merge them
+type MergeReport struct {
+	Prefixes         map[string]string       // Prefix applied to each merged file, by file path
+	PathChanges      map[string][]PathChange // Relative paths made absolute, by file path
+	HostnameRewrites []HostnameRewrite       // Service hostnames pointed at the prefixed names
+	AvoidedPorts     []AvoidedPort           // Host ports skipped because they were in use, when probing
+	Sources          map[string]string       // Compose file each service was declared in, by merged service name
+}
+
+// sortReport orders report entries by the fields key returns, most significant first, so
+// reports built from maps read the same on every run
+func sortReport[T any](entries []T, key func(T) []string) {
+	slices.SortFunc(entries, func(a, b T) int {
+		return slices.Compare(key(a), key(b))
+	})
+}
+
+// copyProject returns a deep copy of the project
+func copyProject(project *types.Project) (*types.Project, error) {
+	// The transform deep-copies the project, leaving the original untouched
+	return project.WithServicesTransform(func(_ string, service types.ServiceConfig) (types.ServiceConfig, error) {
+		return service, nil
+	})
+}
+
+// clone returns a copy of the file whose project can be changed without affecting the original
+func (cf *ComposeFile) clone() (*ComposeFile, error) {
+	project, err := copyProject(cf.Project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy project of %s: %w", cf.Path, err)
+	}
+	copied := *cf
+	copied.Project = project
+	// Included stacks are merged from their own copies, which MergeComposeFiles points the copy at
+	copied.Includes = append([]*ComposeFile(nil), cf.Includes...)
+	return &copied, nil
+}
+
+// MergeComposeFiles merges multiple compose files into a new project, along with a report of the
+// changes made to merge them. The files themselves are left unchanged, so they can be merged again.
+func MergeComposeFiles(files []*ComposeFile, opts ...MergeOption) (*types.Project, *MergeReport, error) {
 	if len(files) == 0 {
-		return nil, fmt.Errorf("no compose files provided")
+		return nil, nil, fmt.Errorf("no compose files provided")
 	}
 
 	logger := logrus.New().WithField("function", "MergeComposeFiles")
 
-	// Use the first file's project as the base
-	baseProject := files[0].Project
+	options := &mergeOptions{
+		sharedNetworks:    make(SharedNetworks),
+		rewriteHostnames:  true,
+		collisionStrategy: CollisionFail,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.verbose {
+		logger.Logger.SetLevel(logrus.DebugLevel)
+	}
+	if options.projectName != "" && loader.NormalizeProjectName(options.projectName) != options.projectName {
+		return nil, nil, fmt.Errorf("invalid project name %q: must contain only lowercase letters, digits, dashes and underscores, and start with a letter or digit", options.projectName)
+	}
 
-	// Adjust build contexts for the base project
-	if err := files[0].adjustBuildContexts(); err != nil {
-		return nil, fmt.Errorf("failed to adjust build contexts for %s: %w", files[0].Path, err)
+	// Included files are merged as stacks of their own, like files given with -f.
+	// Work on copies so that the caller's files stay intact.
+	copies := make(map[*ComposeFile]*ComposeFile)
+	var expanded []*ComposeFile
+	for _, cf := range expandIncludes(files) {
+		copied, err := cf.clone()
+		if err != nil {
+			return nil, nil, err
+		}
+		copies[cf] = copied
+		expanded = append(expanded, copied)
 	}
+	for _, cf := range expanded {
+		for i, included := range cf.Includes {
+			cf.Includes[i] = copies[included]
+		}
+	}
+	files = expanded
 
-	// Get prefix from base directory name
-	basePrefix := filepath.Base(files[0].BaseDir)
-	if err := files[0].prefixResourceNames(basePrefix); err != nil {
-		return nil, fmt.Errorf("failed to prefix resource names for %s: %w", files[0].Path, err)
+	// Mark the shared networks so they are kept unprefixed
+	for _, cf := range files {
+		cf.sharedNetworks = make(map[string]bool)
+		for name := range options.sharedNetworks {
+			cf.sharedNetworks[name] = true
+		}
+		for _, other := range files {
+			for name := range other.Extension.SharedNetworks {
+				cf.sharedNetworks[name] = true
+			}
+		}
 	}
 
-	// Merge additional files
-	for i := 1; i < len(files); i++ {
-		cf := files[i]
+	// Resolve clashing prefixes and resource names before anything is renamed
+	if options.collisionStrategy == CollisionParentDir {
+		disambiguatePrefixes(files, logger)
+	}
+
+	// Make sure every file resolves to its own prefix
+	if err := checkPrefixes(files); err != nil {
+		return nil, nil, err
+	}
+
+	// Leave out services whose profiles are not active in their stack, which profile
+	// selectors name by its final prefix
+	if err := applyProfiles(files, options.profiles); err != nil {
+		return nil, nil, err
+	}
 
-		// Adjust build contexts for the current file
-		if err := cf.adjustBuildContexts(); err != nil {
-			return nil, fmt.Errorf("failed to adjust build contexts for %s: %w", cf.Path, err)
+	// Make sure no two files claim the same resource name
+	if collisions := detectCollisions(files); len(collisions) > 0 {
+		return nil, nil, &CollisionError{Collisions: collisions}
+	}
+
+	// Collect shared networks declared on the command line and in every file
+	shared := collectSharedNetworks(files, options.sharedNetworks)
+
+	// Resolve dependencies across stacks while the prefixes are known
+	dependencies := collectDependencies(files)
+
+	// Resolve the names of included resources before any of them are renamed
+	for _, cf := range files {
+		cf.includedResources = cf.collectIncludedResources()
+	}
+
+	// Prepare every file before merging
+	report := &MergeReport{
+		Prefixes:    make(map[string]string),
+		PathChanges: make(map[string][]PathChange),
+		Sources:     make(map[string]string),
+	}
+	for _, cf := range files {
+		report.Prefixes[cf.Path] = cf.prefix()
+		if err := cf.prepare(options, report, logger); err != nil {
+			return nil, nil, err
 		}
+	}
 
-		// Get prefix from directory name
-		prefix := filepath.Base(cf.BaseDir)
-		if err := cf.prefixResourceNames(prefix); err != nil {
-			return nil, fmt.Errorf("failed to prefix resource names for %s: %w", cf.Path, err)
+	// Services of earlier files keep their ports, so remember the order and the stack of
+	// every service before merging
+	order := serviceOrder(files)
+	stacks := make(map[string]string)
+	for _, cf := range files {
+		for name := range cf.Project.Services {
+			stacks[name] = cf.prefix()
 		}
+	}
+	strategy, err := portStrategy(files, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Carry the extension fields of every file into the merged project
+	extensions := mergeExtensions(files)
+
+	// Use the first file's project as the base
+	baseProject := files[0].Project
+	baseProject.Profiles = activeProfiles(files)
+	baseProject.Extensions = extensions
+	if options.projectName != "" {
+		baseProject.Name = options.projectName
+	}
+
+	// Merge additional files
+	for i := 1; i < len(files); i++ {
+		cf := files[i]
 
 		// Merge services (they are already prefixed)
 		for name, service := range cf.Project.Services {
@@ -123,12 +520,17 @@ func MergeComposeFiles(files []*ComposeFile) (*types.Project, error) {
 			}
 		}
 
-		// Merge networks
+		// Merge networks (they are already prefixed, shared ones keep their first declaration)
 		if cf.Project.Networks != nil {
 			if baseProject.Networks == nil {
 				baseProject.Networks = make(types.Networks)
 			}
 			for name, network := range cf.Project.Networks {
+				_, isShared := shared[name]
+				if _, exists := baseProject.Networks[name]; exists && isShared {
+					logger.Debugf("Shared network %s from %s already declared, keeping first declaration", name, cf.Path)
+					continue
+				}
 				baseProject.Networks[name] = network
 			}
 		}
@@ -154,42 +556,372 @@ func MergeComposeFiles(files []*ComposeFile) (*types.Project, error) {
 		}
 	}
 
+	// Let stacks reach the services of the stacks they include
+	joinIncludingNetworks(baseProject, files, logger)
+
+	// Attach services to the shared networks
+	if err := attachSharedNetworks(baseProject, shared, logger); err != nil {
+		return nil, nil, fmt.Errorf("failed to attach shared networks: %w", err)
+	}
+
+	// Add dependencies on services of other stacks now that every service is merged
+	if err := attachDependencies(baseProject, dependencies, logger); err != nil {
+		return nil, nil, fmt.Errorf("failed to attach dependencies: %w", err)
+	}
+
 	// After merging all files, resolve any port conflicts
-	if err := ResolvePortConflicts(baseProject.Services, 100, logger); err != nil {
-		return nil, fmt.Errorf("failed to resolve port conflicts: %w", err)
+	resolver := PortResolver{Strategy: strategy, Probe: options.portProbe, Order: order, Stacks: stacks}
+	avoided, err := resolver.Resolve(baseProject.Services, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve port conflicts: %w", err)
+	}
+	report.AvoidedPorts = avoided
+
+	return baseProject, report, nil
+}
+
+// portStrategy returns the strategy moving conflicting ports: the one given as an option, or
+// else the one the files configure under x-qec.ports. Stack bases of the stack-base strategy
+// come from the files, overridden by those given as options.
+func portStrategy(files []*ComposeFile, options *mergeOptions) (PortStrategy, error) {
+	strategy := options.portStrategy
+	if strategy == nil {
+		// Every file configuring a strategy must agree on it
+		var settings PortSettings
+		source := ""
+		for _, cf := range files {
+			ports := cf.Extension.Ports
+			if ports.Strategy == "" && ports.Range == "" {
+				continue
+			}
+			if source != "" && (ports.Strategy != settings.Strategy || ports.Range != settings.Range) {
+				return nil, fmt.Errorf("compose files %s and %s configure different port strategies", source, cf.Path)
+			}
+			settings, source = ports, cf.Path
+		}
+		var err error
+		strategy, err = ParsePortStrategy(settings.Strategy, settings.Range)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s.ports in %s: %w", ExtensionKey, source, err)
+		}
+	}
+
+	// Every stack named by a base must exist
+	prefixes := make(map[string]bool)
+	for _, cf := range files {
+		prefixes[cf.prefix()] = true
+	}
+	for stack := range options.portBases {
+		if !prefixes[stack] {
+			return nil, fmt.Errorf("port base %s=%d refers to unknown stack %s", stack, options.portBases[stack], stack)
+		}
+	}
+
+	base, ok := strategy.(StackBaseStrategy)
+	if !ok {
+		if len(options.portBases) > 0 {
+			return nil, fmt.Errorf("port bases only apply to the %s port strategy", PortStrategyStackBase)
+		}
+		return strategy, nil
+	}
+	bases := make(map[string]uint32)
+	for _, cf := range files {
+		if cf.Extension.Ports.Base != 0 {
+			bases[cf.prefix()] = cf.Extension.Ports.Base
+		}
+	}
+	for stack, port := range options.portBases {
+		bases[stack] = port
+	}
+	for stack, port := range base.Bases {
+		bases[stack] = port
+	}
+	return StackBaseStrategy{Bases: bases}, nil
+}
+
+// prefixServiceReferences rewrites "service:name" references in network_mode, ipc, pid, uts, cgroup
+// and build.additional_contexts, as well as volumes_from entries, using the given service name mapping
+func prefixServiceReferences(service *types.ServiceConfig, serviceMap map[string]string) error {
+	logger := logrus.New().WithField("function", "prefixServiceReferences")
+
+	// Resolve a "service:name" reference, leaving other values untouched
+	prefixRef := func(field, value string) (string, error) {
+		target, ok := strings.CutPrefix(value, types.ServicePrefix)
+		if !ok {
+			return value, nil
+		}
+		newName, ok := serviceMap[target]
+		if !ok {
+			return "", fmt.Errorf("%s refers to undefined service %s", field, target)
+		}
+		logger.Debugf("Updated %s reference from %s to %s", field, target, newName)
+		return types.ServicePrefix + newName, nil
+	}
+
+	namespaces := map[string]*string{
+		"network_mode": &service.NetworkMode,
+		"ipc":          &service.Ipc,
+		"pid":          &service.Pid,
+		"uts":          &service.Uts,
+		"cgroup":       &service.Cgroup,
+	}
+	for field, value := range namespaces {
+		newValue, err := prefixRef(field, *value)
+		if err != nil {
+			return err
+		}
+		*value = newValue
+	}
+
+	if service.Build != nil {
+		for key, value := range service.Build.AdditionalContexts {
+			newValue, err := prefixRef("build.additional_contexts."+key, value)
+			if err != nil {
+				return err
+			}
+			service.Build.AdditionalContexts[key] = newValue
+		}
 	}
 
-	return baseProject, nil
+	// volumes_from entries are "service[:mode]" or "container:name[:mode]"
+	for i, entry := range service.VolumesFrom {
+		if strings.HasPrefix(entry, types.ContainerPrefix) {
+			continue
+		}
+		target, mode, hasMode := strings.Cut(entry, ":")
+		newName, ok := serviceMap[target]
+		if !ok {
+			return fmt.Errorf("volumes_from refers to undefined service %s", target)
+		}
+		if hasMode {
+			newName += ":" + mode
+		}
+		logger.Debugf("Updated volumes_from reference from %s to %s", entry, newName)
+		service.VolumesFrom[i] = newName
+	}
+
+	return nil
+}
+
+// keepResourceName reports whether a top-level volume, network, config or secret refers to a resource
+// outside the project and must keep its name, along with the reason. The loader names every resource
+// "<project>_<key>" unless it is external or declares its own name, so any other name is explicit.
+func keepResourceName(projectName, key, name string, external types.External) (string, bool) {
+	if external {
+		return "it is external", true
+	}
+	if name != "" && name != projectName+"_"+key {
+		return fmt.Sprintf("it has the explicit name %s", name), true
+	}
+	return "", false
 }
 
-// prefixResourceNames prefixes all resource names (services, volumes, configs, secrets) with the given prefix
-func (cf *ComposeFile) prefixResourceNames(prefix string) error {
-	logger := logrus.New().WithField("function", "prefixResourceNames")
+// withAlias returns the network configuration with the alias added, creating the configuration if needed
+func withAlias(config *types.ServiceNetworkConfig, alias string) *types.ServiceNetworkConfig {
+	if config == nil {
+		config = &types.ServiceNetworkConfig{}
+	}
+	for _, existing := range config.Aliases {
+		if existing == alias {
+			return config
+		}
+	}
+	config.Aliases = append(config.Aliases, alias)
+	return config
+}
 
-	// Create a map to store old name to new name mappings for dependency updates
-	nameMap := make(map[string]string)
+// includeNames adds the names of included resources to a name mapping, unless the file declares
+// a resource of the same name itself, and marks them in included when given
+func includeNames(mapping, names map[string]string, included map[string]bool) {
+	for name, merged := range names {
+		if _, ok := mapping[name]; ok {
+			continue
+		}
+		mapping[name] = merged
+		if included != nil {
+			included[name] = true
+		}
+	}
+}
+
+// checkPrefixes verifies that every file has a valid prefix and that no two files share one
+func checkPrefixes(files []*ComposeFile) error {
+	seen := make(map[string]string)
+	for _, cf := range files {
+		prefix := cf.prefix()
+		if err := validatePrefix(prefix); err != nil {
+			return fmt.Errorf("compose file %s: %w", cf.Path, err)
+		}
+		if other, ok := seen[prefix]; ok {
+			return fmt.Errorf("compose files %s and %s both resolve to prefix %q; set distinct prefixes with -f FILE:PREFIX, --prefix or x-qec.prefix, or use the parent-dir collision strategy", other, cf.Path, prefix)
+		}
+		seen[prefix] = cf.Path
+	}
+	return nil
+}
+
+// collectSharedNetworks combines shared networks from the merge options and the x-qec settings of every file,
+// resolving each service reference to its merged name
+func collectSharedNetworks(files []*ComposeFile, fromOptions SharedNetworks) map[string][]string {
+	shared := make(map[string][]string)
+
+	for name, network := range fromOptions {
+		shared[name] = []string{}
+		for _, ref := range network.Services {
+			shared[name] = append(shared[name], resolveServiceRef(ref, ""))
+		}
+	}
+
+	for _, cf := range files {
+		for name, network := range cf.Extension.SharedNetworks {
+			if _, ok := shared[name]; !ok {
+				shared[name] = []string{}
+			}
+			for _, ref := range network.Services {
+				shared[name] = append(shared[name], resolveServiceRef(ref, cf.prefix()))
+			}
+		}
+	}
 
-	// Prefix services
+	return shared
+}
+
+// collectDependencies resolves the x-qec-depends-on settings of every file to merged service names
+func collectDependencies(files []*ComposeFile) map[string]Dependencies {
+	resolved := make(map[string]Dependencies)
+	for _, cf := range files {
+		prefix := cf.prefix()
+		for name, deps := range cf.dependencies {
+			service := prefix + "_" + name
+			if resolved[service] == nil {
+				resolved[service] = make(Dependencies)
+			}
+			for ref, dependency := range deps {
+				resolved[service][resolveServiceRef(ref, prefix)] = dependency
+			}
+		}
+	}
+	return resolved
+}
+
+// attachDependencies adds the resolved dependencies to the depends_on of the merged services.
+// Dependencies that are not required may point to services left out of the merge.
+func attachDependencies(project *types.Project, dependencies map[string]Dependencies, logger *logrus.Entry) error {
+	for name, deps := range dependencies {
+		service, ok := project.Services[name]
+		if !ok {
+			// The service itself was left out, e.g. by its profiles
+			continue
+		}
+		for target, dependency := range deps {
+			if _, ok := project.Services[target]; !ok {
+				if !dependency.Required {
+					logger.Debugf("Skipping optional dependency of %s on missing service %s", name, target)
+					continue
+				}
+				return fmt.Errorf("service %s depends on undefined service %s", name, target)
+			}
+			if service.DependsOn == nil {
+				service.DependsOn = make(types.DependsOnConfig)
+			}
+			service.DependsOn[target] = dependency
+			logger.Debugf("Added dependency of %s on %s", name, target)
+		}
+		project.Services[name] = service
+	}
+	return nil
+}
+
+// resolveServiceRef converts a "prefix/service" reference to its merged name.
+// Unqualified references are prefixed with defaultPrefix when one is given.
+func resolveServiceRef(ref, defaultPrefix string) string {
+	if stack, service, ok := strings.Cut(ref, "/"); ok {
+		return stack + "_" + service
+	}
+	if defaultPrefix != "" {
+		return defaultPrefix + "_" + ref
+	}
+	return ref
+}
+
+// attachSharedNetworks makes sure every shared network exists and attaches the requested services to it
+func attachSharedNetworks(project *types.Project, shared map[string][]string, logger *logrus.Entry) error {
+	for name, services := range shared {
+		if project.Networks == nil {
+			project.Networks = make(types.Networks)
+		}
+		if _, ok := project.Networks[name]; !ok {
+			project.Networks[name] = types.NetworkConfig{}
+			logger.Debugf("Created shared network %s", name)
+		}
+
+		for _, serviceName := range services {
+			service, ok := project.Services[serviceName]
+			if !ok {
+				return fmt.Errorf("service %s attached to shared network %s does not exist", serviceName, name)
+			}
+			if service.NetworkMode != "" {
+				return fmt.Errorf("service %s uses network_mode %q and cannot join shared network %s", serviceName, service.NetworkMode, name)
+			}
+			if service.Networks == nil {
+				service.Networks = make(map[string]*types.ServiceNetworkConfig)
+			}
+			if _, ok := service.Networks[name]; !ok {
+				service.Networks[name] = nil
+				logger.Debugf("Attached service %s to shared network %s", serviceName, name)
+			}
+			project.Services[serviceName] = service
+		}
+	}
+	return nil
+}
+
+// prefixResourceNames prefixes all resource names (services, volumes, networks, configs, secrets) with the given prefix.
+// Prefixed resources drop the name the loader derived from the file's own project name, so docker compose
+// names them after the merged project and their new key.
+func (cf *ComposeFile) prefixResourceNames(prefix string, logger *logrus.Entry) error {
+	// Prefix services, remembering their original names for network aliases
 	newServices := make(types.Services)
+	serviceMap := make(map[string]string)
+	originalNames := make(map[string]string)
 	for name, service := range cf.Project.Services {
 		newName := prefix + "_" + name
-		nameMap[name] = newName
+		serviceMap[name] = newName
+		originalNames[newName] = name
 		newServices[newName] = service
 		logger.Debugf("Prefixed service name from %s to %s", name, newName)
 	}
 	cf.Project.Services = newServices
+	includeNames(serviceMap, cf.includedResources["service"], nil)
+
+	// Update references to other services in namespace, volumes_from and build context fields
+	for name, service := range cf.Project.Services {
+		if err := prefixServiceReferences(&service, serviceMap); err != nil {
+			return fmt.Errorf("service %s: %w", originalNames[name], err)
+		}
+		cf.Project.Services[name] = service
+	}
 
 	// Prefix volumes
+	volumeMap := make(map[string]string)
 	if cf.Project.Volumes != nil {
 		newVolumes := make(types.Volumes)
 		for name, volume := range cf.Project.Volumes {
+			if reason, keep := keepResourceName(cf.Project.Name, name, volume.Name, volume.External); keep {
+				volumeMap[name] = name
+				newVolumes[name] = volume
+				logger.Debugf("Keeping volume name %s because %s", name, reason)
+				continue
+			}
 			newName := prefix + "_" + name
-			nameMap[name] = newName
+			volumeMap[name] = newName
+			volume.Name = ""
 			newVolumes[newName] = volume
 			logger.Debugf("Prefixed volume name from %s to %s", name, newName)
 		}
 		cf.Project.Volumes = newVolumes
 	}
+	includeNames(volumeMap, cf.includedResources["volume"], nil)
 
 	// Update service volume references
 	for name, service := range cf.Project.Services {
@@ -198,9 +930,9 @@ func (cf *ComposeFile) prefixResourceNames(prefix string) error {
 			for i, volume := range service.Volumes {
 				if volume.Source != "" {
 					// If the volume source is a named volume, update its reference
-					if newName, ok := nameMap[volume.Source]; ok {
-						volume.Source = newName
+					if newName, ok := volumeMap[volume.Source]; ok && newName != volume.Source {
 						logger.Debugf("Updated volume reference in service %s from %s to %s", name, volume.Source, newName)
+						volume.Source = newName
 					}
 				}
 				newVolumes[i] = volume
@@ -210,29 +942,134 @@ func (cf *ComposeFile) prefixResourceNames(prefix string) error {
 		}
 	}
 
+	// Prefix networks, including the implicit default network
+	networkMap := make(map[string]string)
+	keptNetworks := make(map[string]bool)
+	if cf.Project.Networks != nil {
+		newNetworks := make(types.Networks)
+		for name, network := range cf.Project.Networks {
+			if cf.sharedNetworks[name] {
+				// Shared networks are named after the merged project unless they name themselves
+				if _, keep := keepResourceName(cf.Project.Name, name, network.Name, network.External); !keep {
+					network.Name = ""
+				}
+				networkMap[name] = name
+				newNetworks[name] = network
+				logger.Debugf("Keeping shared network name %s", name)
+				continue
+			}
+			if reason, keep := keepResourceName(cf.Project.Name, name, network.Name, network.External); keep {
+				networkMap[name] = name
+				keptNetworks[name] = true
+				newNetworks[name] = network
+				logger.Debugf("Keeping network name %s because %s", name, reason)
+				continue
+			}
+			newName := prefix + "_" + name
+			networkMap[name] = newName
+			network.Name = ""
+			newNetworks[newName] = network
+			logger.Debugf("Prefixed network name from %s to %s", name, newName)
+		}
+		cf.Project.Networks = newNetworks
+	}
+	// Networks of included stacks get no alias, like kept ones
+	includeNames(networkMap, cf.includedResources["network"], keptNetworks)
+
+	// Update service network references, keeping aliases, addresses and priorities.
+	// The original service name is added as an alias on the file's own networks so
+	// that in-stack DNS keeps resolving it.
+	for name, service := range cf.Project.Services {
+		if service.Networks != nil {
+			newNetworks := make(map[string]*types.ServiceNetworkConfig, len(service.Networks))
+			for netName, config := range service.Networks {
+				newName, ok := networkMap[netName]
+				if !ok {
+					newName = prefix + "_" + netName
+				}
+				if !cf.sharedNetworks[netName] && !keptNetworks[netName] {
+					config = withAlias(config, originalNames[name])
+					logger.Debugf("Added network alias %s for service %s on network %s", originalNames[name], name, newName)
+				}
+				newNetworks[newName] = config
+				logger.Debugf("Updated network reference in service %s from %s to %s", name, netName, newName)
+			}
+			service.Networks = newNetworks
+			cf.Project.Services[name] = service
+		}
+	}
+
 	// Prefix configs
+	configMap := make(map[string]string)
 	if cf.Project.Configs != nil {
 		newConfigs := make(types.Configs)
 		for name, config := range cf.Project.Configs {
+			if reason, keep := keepResourceName(cf.Project.Name, name, config.Name, config.External); keep {
+				configMap[name] = name
+				newConfigs[name] = config
+				logger.Debugf("Keeping config name %s because %s", name, reason)
+				continue
+			}
 			newName := prefix + "_" + name
-			nameMap[name] = newName
+			configMap[name] = newName
+			config.Name = ""
 			newConfigs[newName] = config
 			logger.Debugf("Prefixed config name from %s to %s", name, newName)
 		}
 		cf.Project.Configs = newConfigs
 	}
+	includeNames(configMap, cf.includedResources["config"], nil)
 
 	// Prefix secrets
+	secretMap := make(map[string]string)
 	if cf.Project.Secrets != nil {
 		newSecrets := make(types.Secrets)
 		for name, secret := range cf.Project.Secrets {
+			if reason, keep := keepResourceName(cf.Project.Name, name, secret.Name, secret.External); keep {
+				secretMap[name] = name
+				newSecrets[name] = secret
+				logger.Debugf("Keeping secret name %s because %s", name, reason)
+				continue
+			}
 			newName := prefix + "_" + name
-			nameMap[name] = newName
+			secretMap[name] = newName
+			secret.Name = ""
 			newSecrets[newName] = secret
 			logger.Debugf("Prefixed secret name from %s to %s", name, newName)
 		}
 		cf.Project.Secrets = newSecrets
 	}
+	includeNames(secretMap, cf.includedResources["secret"], nil)
+
+	// Update service config and secret references. Targets default to the original
+	// name so that applications still find the files where they expect them.
+	for name, service := range cf.Project.Services {
+		for i, config := range service.Configs {
+			newName, ok := configMap[config.Source]
+			if !ok || newName == config.Source {
+				continue
+			}
+			if config.Target == "" {
+				config.Target = "/" + config.Source
+			}
+			logger.Debugf("Updated config reference in service %s from %s to %s (target %s)", name, config.Source, newName, config.Target)
+			config.Source = newName
+			service.Configs[i] = config
+		}
+		for i, secret := range service.Secrets {
+			newName, ok := secretMap[secret.Source]
+			if !ok || newName == secret.Source {
+				continue
+			}
+			if secret.Target == "" {
+				secret.Target = "/run/secrets/" + secret.Source
+			}
+			logger.Debugf("Updated secret reference in service %s from %s to %s (target %s)", name, secret.Source, newName, secret.Target)
+			secret.Source = newName
+			service.Secrets[i] = secret
+		}
+		cf.Project.Services[name] = service
+	}
 
 	// Update service dependencies to use prefixed names
 	for name, service := range cf.Project.Services {
@@ -240,7 +1077,10 @@ func (cf *ComposeFile) prefixResourceNames(prefix string) error {
 		if service.DependsOn != nil {
 			newDependsOn := make(types.DependsOnConfig)
 			for depName, config := range service.DependsOn {
-				newName := prefix + "_" + depName
+				newName, ok := serviceMap[depName]
+				if !ok {
+					newName = prefix + "_" + depName
+				}
 				newDependsOn[newName] = config
 				logger.Debugf("Updated dependency from %s to %s", depName, newName)
 			}
@@ -252,16 +1092,16 @@ func (cf *ComposeFile) prefixResourceNames(prefix string) error {
 		if service.Links != nil {
 			newLinks := make([]string, len(service.Links))
 			for i, link := range service.Links {
-				parts := strings.Split(link, ":")
-				if len(parts) == 2 {
-					newName := prefix + "_" + parts[0]
-					newLinks[i] = newName + ":" + parts[1]
-					logger.Debugf("Updated link from %s to %s", link, newLinks[i])
-				} else {
-					newName := prefix + "_" + link
-					newLinks[i] = newName
-					logger.Debugf("Updated link from %s to %s", link, newName)
+				target, alias, hasAlias := strings.Cut(link, ":")
+				newName, ok := serviceMap[target]
+				if !ok {
+					newName = prefix + "_" + target
+				}
+				newLinks[i] = newName
+				if hasAlias {
+					newLinks[i] += ":" + alias
 				}
+				logger.Debugf("Updated link from %s to %s", link, newLinks[i])
 			}
 			service.Links = newLinks
 			cf.Project.Services[name] = service
@@ -1,10 +1,15 @@
 package compose
 
 import (
+	"bytes"
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/compose-spec/compose-go/v2/loader"
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -21,6 +26,26 @@ func (suite *MergeTestSuite) SetupTest() {
 	suite.tmpDir = suite.T().TempDir()
 }
 
+// writeFile writes content to the named file below dir, creating parent directories, and
+// returns the file's path
+func writeFile(t *testing.T, dir, name, content string) string {
+	file := filepath.Join(dir, name)
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	require.NoError(t, err)
+	err = os.WriteFile(file, []byte(content), 0644)
+	require.NoError(t, err)
+	return file
+}
+
+// debugLogger returns a debug-level logger writing to the returned buffer
+func debugLogger() (*logrus.Entry, *bytes.Buffer) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetLevel(logrus.DebugLevel)
+	return logger.WithField("test", true), &out
+}
+
 // TestNewComposeFile tests loading a single compose file
 func (suite *MergeTestSuite) TestNewComposeFile() {
 	// Create a test compose file
@@ -57,6 +82,58 @@ services:
 	assert.Equal(suite.T(), filepath.Join(suite.tmpDir, "app"), service.Build.Context)
 }
 
+// TestNewComposeFileWithOverrides tests deep-merging override files into the base file
+func (suite *MergeTestSuite) TestNewComposeFileWithOverrides() {
+	baseFile := filepath.Join(suite.tmpDir, "docker-compose.yml")
+	err := os.WriteFile(baseFile, []byte(`
+services:
+  app:
+    image: nginx
+    environment:
+      LOG_LEVEL: info
+      PORT: "8080"
+    ports:
+      - "80:80"
+  db:
+    image: postgres
+`), 0644)
+	require.NoError(suite.T(), err)
+
+	overrideFile := filepath.Join(suite.tmpDir, "docker-compose.override.yml")
+	err = os.WriteFile(overrideFile, []byte(`
+services:
+  app:
+    environment:
+      LOG_LEVEL: debug
+    ports:
+      - "9229:9229"
+  debug:
+    image: busybox
+`), 0644)
+	require.NoError(suite.T(), err)
+
+	cf, err := NewComposeFile(baseFile, overrideFile)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), baseFile, cf.Path)
+	assert.Equal(suite.T(), []string{overrideFile}, cf.Overrides)
+
+	// Verify that services are deep-merged rather than duplicated
+	assert.Len(suite.T(), cf.Project.Services, 3)
+	app := cf.Project.Services["app"]
+	assert.Equal(suite.T(), "nginx", app.Image)
+	assert.Equal(suite.T(), "debug", *app.Environment["LOG_LEVEL"])
+	assert.Equal(suite.T(), "8080", *app.Environment["PORT"])
+	assert.Len(suite.T(), app.Ports, 2)
+
+	// Verify that the merged stack is prefixed as a single stack
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf})
+	require.NoError(suite.T(), err)
+	prefix := filepath.Base(suite.tmpDir)
+	assert.Contains(suite.T(), merged.Services, prefix+"_app")
+	assert.Contains(suite.T(), merged.Services, prefix+"_db")
+	assert.Contains(suite.T(), merged.Services, prefix+"_debug")
+}
+
 // TestMergeComposeFiles tests merging multiple compose files
 func (suite *MergeTestSuite) TestMergeComposeFiles() {
 	// Create the first compose file in a subdirectory
@@ -114,7 +191,7 @@ services:
 	cf2, err := NewComposeFile(file2)
 	require.NoError(suite.T(), err)
 
-	merged, err := MergeComposeFiles([]*ComposeFile{cf1, cf2})
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf1, cf2})
 	require.NoError(suite.T(), err)
 
 	// Verify merged configuration
@@ -167,8 +244,7 @@ services:
 	require.NoError(suite.T(), err)
 
 	// Adjust build contexts
-	err = cf.adjustBuildContexts()
-	require.NoError(suite.T(), err)
+	cf.normalizePaths()
 
 	// Verify the adjusted build contexts
 	app1 := cf.Project.Services["app1"]
@@ -178,6 +254,85 @@ services:
 	assert.Equal(suite.T(), "/absolute/path", app2.Build.Context)
 }
 
+// writeExtendsChain writes a stack whose service extends a service in another directory,
+// named with an interpolated path, which itself extends a service in a third directory
+func (suite *MergeTestSuite) writeExtendsChain() string {
+	files := map[string]string{
+		filepath.Join("web", "docker-compose.yml"): `
+services:
+  app:
+    extends:
+      file: ${COMMON_DIR:-../common}/base.yml
+      service: base
+    environment:
+      MODE: web
+`,
+		filepath.Join("common", "base.yml"): `
+services:
+  base:
+    extends:
+      file: ../shared/root.yml
+      service: root
+    build: ./app
+    env_file: ./base.env
+    volumes:
+      - ./data:/data
+`,
+		filepath.Join("shared", "root.yml"): `
+services:
+  root:
+    image: node
+    env_file: ./root.env
+    volumes:
+      - ./tools:/tools
+`,
+		filepath.Join("common", "base.env"):     "",
+		filepath.Join("shared", "root.env"):     "",
+		filepath.Join("common", "app", ".keep"): "",
+	}
+	for name, content := range files {
+		writeFile(suite.T(), suite.tmpDir, name, content)
+	}
+	return filepath.Join(suite.tmpDir, "web", "docker-compose.yml")
+}
+
+// TestMergeComposeFilesWithMultiLevelExtends tests that paths inherited through extends resolve
+// against the directory of the file declaring them
+func (suite *MergeTestSuite) TestMergeComposeFilesWithMultiLevelExtends() {
+	cf, err := NewComposeFile(suite.writeExtendsChain())
+	require.NoError(suite.T(), err)
+
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf})
+	require.NoError(suite.T(), err)
+
+	app, ok := merged.Services["web_app"]
+	require.True(suite.T(), ok)
+	assert.Equal(suite.T(), "node", app.Image)
+
+	// Verify that the build context comes from the intermediate file's directory
+	require.NotNil(suite.T(), app.Build)
+	assert.Equal(suite.T(), filepath.Join(suite.tmpDir, "common", "app"), app.Build.Context)
+
+	// Verify that env files and bind mounts keep the directory of each level
+	var envFiles []string
+	for _, envFile := range app.EnvFiles {
+		envFiles = append(envFiles, envFile.Path)
+	}
+	assert.ElementsMatch(suite.T(), []string{
+		filepath.Join(suite.tmpDir, "shared", "root.env"),
+		filepath.Join(suite.tmpDir, "common", "base.env"),
+	}, envFiles)
+
+	var sources []string
+	for _, volume := range app.Volumes {
+		sources = append(sources, volume.Source)
+	}
+	assert.ElementsMatch(suite.T(), []string{
+		filepath.Join(suite.tmpDir, "shared", "tools"),
+		filepath.Join(suite.tmpDir, "common", "data"),
+	}, sources)
+}
+
 // TestPrefixResourceNames tests the resource name prefixing functionality
 func (suite *MergeTestSuite) TestPrefixResourceNames() {
 	// Create a test compose file with various resources
@@ -222,7 +377,7 @@ secrets:
 
 	// Test prefixing with a sample prefix
 	prefix := "test"
-	err = cf.prefixResourceNames(prefix)
+	err = cf.prefixResourceNames(prefix, logrus.New().WithField("test", true))
 	require.NoError(suite.T(), err)
 
 	// Verify service names are prefixed
@@ -247,6 +402,189 @@ secrets:
 	assert.Contains(suite.T(), appService.Links, prefix+"_redis:redis")
 }
 
+// TestPrefixServiceReferences tests rewriting of service references in namespace and volumes_from fields
+func (suite *MergeTestSuite) TestPrefixServiceReferences() {
+	testFile := filepath.Join(suite.tmpDir, "docker-compose.yml")
+	content := []byte(`
+services:
+  vpn:
+    image: vpn
+    build:
+      context: .
+  app:
+    image: nginx
+    network_mode: "service:vpn"
+    ipc: "service:vpn"
+    pid: "service:vpn"
+    volumes_from:
+      - data
+      - data:ro
+      - container:external
+  data:
+    image: busybox
+  builder:
+    build:
+      context: .
+      additional_contexts:
+        base: "service:vpn"
+`)
+	err := os.WriteFile(testFile, content, 0644)
+	require.NoError(suite.T(), err)
+
+	cf, err := NewComposeFile(testFile)
+	require.NoError(suite.T(), err)
+
+	err = cf.prefixResourceNames("test", logrus.New().WithField("test", true))
+	require.NoError(suite.T(), err)
+
+	app := cf.Project.Services["test_app"]
+	assert.Equal(suite.T(), "service:test_vpn", app.NetworkMode)
+	assert.Equal(suite.T(), "service:test_vpn", app.Ipc)
+	assert.Equal(suite.T(), "service:test_vpn", app.Pid)
+	assert.Equal(suite.T(), []string{"test_data", "test_data:ro", "container:external"}, app.VolumesFrom)
+
+	builder := cf.Project.Services["test_builder"]
+	assert.Equal(suite.T(), "service:test_vpn", builder.Build.AdditionalContexts["base"])
+}
+
+// TestPrefixServiceReferencesUndefined tests that references to unknown services are reported
+func (suite *MergeTestSuite) TestPrefixServiceReferencesUndefined() {
+	serviceMap := map[string]string{"app": "test_app"}
+
+	service := types.ServiceConfig{Pid: "service:missing"}
+	err := prefixServiceReferences(&service, serviceMap)
+	assert.EqualError(suite.T(), err, "pid refers to undefined service missing")
+
+	service = types.ServiceConfig{VolumesFrom: []string{"missing:ro"}}
+	err = prefixServiceReferences(&service, serviceMap)
+	assert.EqualError(suite.T(), err, "volumes_from refers to undefined service missing")
+}
+
+// TestPrefixConfigAndSecretReferences tests rewriting of service config and secret references
+func (suite *MergeTestSuite) TestPrefixConfigAndSecretReferences() {
+	testFile := filepath.Join(suite.tmpDir, "docker-compose.yml")
+	content := []byte(`
+services:
+  app:
+    image: nginx
+    configs:
+      - app_config
+      - source: nginx_config
+        target: /etc/nginx/nginx.conf
+    secrets:
+      - db_password
+      - source: api_key
+        target: /custom/api_key
+configs:
+  app_config:
+    file: ./config.json
+  nginx_config:
+    file: ./nginx.conf
+secrets:
+  db_password:
+    file: ./db_password.txt
+  api_key:
+    file: ./api_key.txt
+`)
+	err := os.WriteFile(testFile, content, 0644)
+	require.NoError(suite.T(), err)
+
+	cf, err := NewComposeFile(testFile)
+	require.NoError(suite.T(), err)
+
+	err = cf.prefixResourceNames("test", logrus.New().WithField("test", true))
+	require.NoError(suite.T(), err)
+
+	app := cf.Project.Services["test_app"]
+
+	// Verify config sources point at the prefixed configs and targets keep the original names
+	require.Len(suite.T(), app.Configs, 2)
+	assert.Equal(suite.T(), "test_app_config", app.Configs[0].Source)
+	assert.Equal(suite.T(), "/app_config", app.Configs[0].Target)
+	assert.Equal(suite.T(), "test_nginx_config", app.Configs[1].Source)
+	assert.Equal(suite.T(), "/etc/nginx/nginx.conf", app.Configs[1].Target)
+
+	// Verify secret sources point at the prefixed secrets and targets keep the original names
+	require.Len(suite.T(), app.Secrets, 2)
+	assert.Equal(suite.T(), "test_db_password", app.Secrets[0].Source)
+	assert.Equal(suite.T(), "/run/secrets/db_password", app.Secrets[0].Target)
+	assert.Equal(suite.T(), "test_api_key", app.Secrets[1].Source)
+	assert.Equal(suite.T(), "/custom/api_key", app.Secrets[1].Target)
+}
+
+// TestPrefixResourceNamesKeepsExternalResources tests that external and explicitly named resources keep their names
+func (suite *MergeTestSuite) TestPrefixResourceNamesKeepsExternalResources() {
+	testFile := filepath.Join(suite.tmpDir, "docker-compose.yml")
+	content := []byte(`
+services:
+  app:
+    image: nginx
+    volumes:
+      - shared_data:/shared
+      - named_data:/named
+      - local_data:/local
+    networks:
+      - proxy
+      - backend
+    configs:
+      - shared_config
+    secrets:
+      - shared_secret
+volumes:
+  shared_data:
+    external: true
+  named_data:
+    name: my-named-data
+  local_data: {}
+networks:
+  proxy:
+    external: true
+  backend: {}
+configs:
+  shared_config:
+    external: true
+secrets:
+  shared_secret:
+    external: true
+`)
+	err := os.WriteFile(testFile, content, 0644)
+	require.NoError(suite.T(), err)
+
+	cf, err := NewComposeFile(testFile)
+	require.NoError(suite.T(), err)
+
+	logger, out := debugLogger()
+	err = cf.prefixResourceNames("test", logger)
+	require.NoError(suite.T(), err)
+
+	// Verify external and explicitly named resources keep their names
+	assert.Contains(suite.T(), cf.Project.Volumes, "shared_data")
+	assert.Contains(suite.T(), cf.Project.Volumes, "named_data")
+	assert.Contains(suite.T(), cf.Project.Volumes, "test_local_data")
+	assert.Contains(suite.T(), cf.Project.Networks, "proxy")
+	assert.Contains(suite.T(), cf.Project.Networks, "test_backend")
+	assert.Contains(suite.T(), cf.Project.Configs, "shared_config")
+	assert.Contains(suite.T(), cf.Project.Secrets, "shared_secret")
+
+	// Verify references to them are left untouched
+	app := cf.Project.Services["test_app"]
+	assert.Equal(suite.T(), "shared_data", app.Volumes[0].Source)
+	assert.Equal(suite.T(), "named_data", app.Volumes[1].Source)
+	assert.Equal(suite.T(), "test_local_data", app.Volumes[2].Source)
+	assert.Contains(suite.T(), app.Networks, "proxy")
+	assert.Contains(suite.T(), app.Networks, "test_backend")
+	assert.Equal(suite.T(), "shared_config", app.Configs[0].Source)
+	assert.Equal(suite.T(), "shared_secret", app.Secrets[0].Source)
+
+	// Verify no alias is added on external networks
+	assert.Nil(suite.T(), app.Networks["proxy"])
+
+	// Verify that the reasons for keeping the names are logged
+	assert.Contains(suite.T(), out.String(), "Keeping volume name shared_data because it is external")
+	assert.Contains(suite.T(), out.String(), "Keeping volume name named_data because it has the explicit name my-named-data")
+	assert.Contains(suite.T(), out.String(), "Keeping network name proxy because it is external")
+}
+
 // TestMergeComposeFilesWithPrefixing tests merging compose files with resource name prefixing
 func (suite *MergeTestSuite) TestMergeComposeFilesWithPrefixing() {
 	// Create the first compose file
@@ -297,7 +635,7 @@ volumes:
 	cf2, err := NewComposeFile(file2)
 	require.NoError(suite.T(), err)
 
-	merged, err := MergeComposeFiles([]*ComposeFile{cf1, cf2})
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf1, cf2})
 	require.NoError(suite.T(), err)
 
 	// Verify that services from both files are present with correct prefixes
@@ -318,6 +656,367 @@ volumes:
 	assert.Contains(suite.T(), folder2App.DependsOn, "folder2_db")
 }
 
+// TestMergeComposeFilesWithConfiguredPrefixes tests explicit and x-qec prefixes
+func (suite *MergeTestSuite) TestMergeComposeFilesWithConfiguredPrefixes() {
+	// Create two stacks in directories with the same name
+	file1 := filepath.Join(suite.tmpDir, "services", "api", "docker-compose.yml")
+	err := os.MkdirAll(filepath.Dir(file1), 0755)
+	require.NoError(suite.T(), err)
+	err = os.WriteFile(file1, []byte(`
+services:
+  app:
+    image: nginx
+`), 0644)
+	require.NoError(suite.T(), err)
+
+	file2 := filepath.Join(suite.tmpDir, "legacy", "api", "docker-compose.yml")
+	err = os.MkdirAll(filepath.Dir(file2), 0755)
+	require.NoError(suite.T(), err)
+	err = os.WriteFile(file2, []byte(`
+services:
+  app:
+    image: httpd
+x-qec:
+  prefix: legacy
+`), 0644)
+	require.NoError(suite.T(), err)
+
+	cf1, err := NewComposeFile(file1)
+	require.NoError(suite.T(), err)
+	cf2, err := NewComposeFile(file2)
+	require.NoError(suite.T(), err)
+
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf1, cf2})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "nginx", merged.Services["api_app"].Image)
+	assert.Equal(suite.T(), "httpd", merged.Services["legacy_app"].Image)
+
+	// An explicit prefix takes precedence over x-qec.prefix
+	cf1, err = NewComposeFile(file1)
+	require.NoError(suite.T(), err)
+	cf2, err = NewComposeFile(file2)
+	require.NoError(suite.T(), err)
+	cf2.Prefix = "old"
+
+	merged, _, err = MergeComposeFiles([]*ComposeFile{cf1, cf2})
+	require.NoError(suite.T(), err)
+	assert.Contains(suite.T(), merged.Services, "old_app")
+	assert.NotContains(suite.T(), merged.Services, "legacy_app")
+}
+
+// TestMergeComposeFilesWithDuplicatePrefixes tests that files resolving to the same prefix are rejected
+func (suite *MergeTestSuite) TestMergeComposeFilesWithDuplicatePrefixes() {
+	var files []*ComposeFile
+	for _, dir := range []string{"services", "legacy"} {
+		file := filepath.Join(suite.tmpDir, dir, "api", "docker-compose.yml")
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		require.NoError(suite.T(), err)
+		err = os.WriteFile(file, []byte(`
+services:
+  app:
+    image: nginx
+`), 0644)
+		require.NoError(suite.T(), err)
+
+		cf, err := NewComposeFile(file)
+		require.NoError(suite.T(), err)
+		files = append(files, cf)
+	}
+
+	_, _, err := MergeComposeFiles(files)
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), `both resolve to prefix "api"`)
+}
+
+// TestMergeComposeFilesWithNetworks tests that networks are isolated per compose file
+func (suite *MergeTestSuite) TestMergeComposeFilesWithNetworks() {
+	// Create the first compose file
+	file1 := filepath.Join(suite.tmpDir, "web", "docker-compose.yml")
+	err := os.MkdirAll(filepath.Dir(file1), 0755)
+	require.NoError(suite.T(), err)
+	content1 := []byte(`
+services:
+  app:
+    image: nginx
+    networks:
+      backend:
+        aliases:
+          - api
+        ipv4_address: 172.28.0.10
+        priority: 100
+  cache:
+    image: redis
+networks:
+  backend:
+    ipam:
+      config:
+        - subnet: 172.28.0.0/16
+`)
+	err = os.WriteFile(file1, content1, 0644)
+	require.NoError(suite.T(), err)
+
+	// Create the second compose file declaring a network with the same name
+	file2 := filepath.Join(suite.tmpDir, "db", "docker-compose.yml")
+	err = os.MkdirAll(filepath.Dir(file2), 0755)
+	require.NoError(suite.T(), err)
+	content2 := []byte(`
+services:
+  postgres:
+    image: postgres
+    networks:
+      - backend
+  admin:
+    image: adminer
+networks:
+  backend:
+    driver: bridge
+`)
+	err = os.WriteFile(file2, content2, 0644)
+	require.NoError(suite.T(), err)
+
+	// Load and merge the compose files
+	cf1, err := NewComposeFile(file1)
+	require.NoError(suite.T(), err)
+	cf2, err := NewComposeFile(file2)
+	require.NoError(suite.T(), err)
+
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf1, cf2})
+	require.NoError(suite.T(), err)
+
+	// Verify that networks from both files are present with correct prefixes
+	assert.Contains(suite.T(), merged.Networks, "web_backend")
+	assert.Contains(suite.T(), merged.Networks, "db_backend")
+	assert.NotContains(suite.T(), merged.Networks, "backend")
+
+	// Verify that each file gets its own default network
+	assert.Contains(suite.T(), merged.Networks, "web_default")
+	assert.Contains(suite.T(), merged.Networks, "db_default")
+	assert.NotContains(suite.T(), merged.Networks, "default")
+
+	// Verify that service network references are updated and keep their settings
+	app := merged.Services["web_app"]
+	require.Contains(suite.T(), app.Networks, "web_backend")
+	appNetwork := app.Networks["web_backend"]
+	require.NotNil(suite.T(), appNetwork)
+	assert.Equal(suite.T(), []string{"api", "app"}, appNetwork.Aliases)
+	assert.Equal(suite.T(), "172.28.0.10", appNetwork.Ipv4Address)
+	assert.Equal(suite.T(), 100, appNetwork.Priority)
+
+	assert.Contains(suite.T(), merged.Services["web_cache"].Networks, "web_default")
+	assert.Contains(suite.T(), merged.Services["db_postgres"].Networks, "db_backend")
+	assert.Contains(suite.T(), merged.Services["db_admin"].Networks, "db_default")
+}
+
+// TestMergeComposeFilesWithSharedProjectName tests that stacks sharing a project name get resources
+// of their own, named after the merged project
+func (suite *MergeTestSuite) TestMergeComposeFilesWithSharedProjectName() {
+	var files []*ComposeFile
+	for _, dir := range []string{"web", "db"} {
+		file := writeFile(suite.T(), suite.tmpDir, filepath.Join(dir, "docker-compose.yml"), `
+name: shop
+services:
+  app:
+    image: nginx
+    networks: [backend]
+    volumes: [data:/data]
+    configs: [settings]
+    secrets: [token]
+networks:
+  backend: {}
+volumes:
+  data: {}
+configs:
+  settings:
+    file: ./settings.conf
+secrets:
+  token:
+    file: ./token.txt
+`)
+		cf, err := NewComposeFile(file)
+		require.NoError(suite.T(), err)
+		files = append(files, cf)
+	}
+
+	for _, project := range []string{"dev", "ci"} {
+		merged, _, err := MergeComposeFiles(files, WithProjectName(project))
+		require.NoError(suite.T(), err)
+
+		// Load the merged file the way docker compose does to get the names it uses
+		out, err := merged.MarshalYAML()
+		require.NoError(suite.T(), err)
+		loaded, err := loader.LoadWithContext(context.Background(), types.ConfigDetails{
+			WorkingDir:  suite.tmpDir,
+			ConfigFiles: []types.ConfigFile{{Filename: "docker-compose.merged.yml", Content: out}},
+		}, func(o *loader.Options) {
+			o.SkipConsistencyCheck = true
+		})
+		require.NoError(suite.T(), err)
+
+		for _, prefix := range []string{"web", "db"} {
+			assert.Equal(suite.T(), project+"_"+prefix+"_backend", loaded.Networks[prefix+"_backend"].Name)
+			assert.Equal(suite.T(), project+"_"+prefix+"_data", loaded.Volumes[prefix+"_data"].Name)
+			assert.Equal(suite.T(), project+"_"+prefix+"_settings", loaded.Configs[prefix+"_settings"].Name)
+			assert.Equal(suite.T(), project+"_"+prefix+"_token", loaded.Secrets[prefix+"_token"].Name)
+		}
+	}
+}
+
+// TestMergeComposeFilesWithNetworkAliases tests that prefixed services keep their original name as an alias
+func (suite *MergeTestSuite) TestMergeComposeFilesWithNetworkAliases() {
+	testFile := filepath.Join(suite.tmpDir, "web", "docker-compose.yml")
+	err := os.MkdirAll(filepath.Dir(testFile), 0755)
+	require.NoError(suite.T(), err)
+	err = os.WriteFile(testFile, []byte(`
+services:
+  app:
+    image: nginx
+    networks:
+      - default
+      - backend
+      - shared
+  redis:
+    image: redis
+networks:
+  backend: {}
+  shared: {}
+x-qec:
+  shared_networks: [shared]
+`), 0644)
+	require.NoError(suite.T(), err)
+
+	cf, err := NewComposeFile(testFile)
+	require.NoError(suite.T(), err)
+
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf})
+	require.NoError(suite.T(), err)
+
+	// Verify that the original names are aliases on the stack's own networks
+	app := merged.Services["web_app"]
+	require.NotNil(suite.T(), app.Networks["web_default"])
+	assert.Equal(suite.T(), []string{"app"}, app.Networks["web_default"].Aliases)
+	require.NotNil(suite.T(), app.Networks["web_backend"])
+	assert.Equal(suite.T(), []string{"app"}, app.Networks["web_backend"].Aliases)
+
+	redis := merged.Services["web_redis"]
+	require.NotNil(suite.T(), redis.Networks["web_default"])
+	assert.Equal(suite.T(), []string{"redis"}, redis.Networks["web_default"].Aliases)
+
+	// Verify that shared networks get no alias, as names would clash across stacks
+	assert.Nil(suite.T(), app.Networks["shared"])
+
+	// Verify that the aliases are written to the merged file
+	yaml, err := merged.MarshalYAML()
+	require.NoError(suite.T(), err)
+	assert.Contains(suite.T(), string(yaml), "aliases:\n          - redis")
+}
+
+// TestPrefixResourceNamesLogsAliases tests that added aliases are logged with the logger passed in,
+// so they show up with --verbose
+func (suite *MergeTestSuite) TestPrefixResourceNamesLogsAliases() {
+	testFile := writeFile(suite.T(), suite.tmpDir, filepath.Join("web", "docker-compose.yml"), `
+services:
+  app:
+    image: nginx
+`)
+	cf, err := NewComposeFile(testFile)
+	require.NoError(suite.T(), err)
+
+	logger, out := debugLogger()
+	require.NoError(suite.T(), cf.prefixResourceNames("web", logger))
+	assert.Contains(suite.T(), out.String(), "Added network alias app for service web_app on network web_default")
+}
+
+// TestMergeComposeFilesWithSharedNetworks tests sharing networks across compose files
+func (suite *MergeTestSuite) TestMergeComposeFilesWithSharedNetworks() {
+	// Create the first compose file joining the shared network itself
+	file1 := filepath.Join(suite.tmpDir, "web", "docker-compose.yml")
+	err := os.MkdirAll(filepath.Dir(file1), 0755)
+	require.NoError(suite.T(), err)
+	content1 := []byte(`
+services:
+  app:
+    image: nginx
+    networks:
+      - default
+      - backend
+networks:
+  backend:
+    driver: bridge
+x-qec:
+  shared_networks:
+    - backend
+`)
+	err = os.WriteFile(file1, content1, 0644)
+	require.NoError(suite.T(), err)
+
+	// Create the second compose file attaching its service through x-qec
+	file2 := filepath.Join(suite.tmpDir, "db", "docker-compose.yml")
+	err = os.MkdirAll(filepath.Dir(file2), 0755)
+	require.NoError(suite.T(), err)
+	content2 := []byte(`
+services:
+  postgres:
+    image: postgres
+  backup:
+    image: alpine
+x-qec:
+  shared_networks:
+    backend:
+      services: [postgres]
+`)
+	err = os.WriteFile(file2, content2, 0644)
+	require.NoError(suite.T(), err)
+
+	// Load and merge the compose files, sharing another network from the options
+	cf1, err := NewComposeFile(file1)
+	require.NoError(suite.T(), err)
+	cf2, err := NewComposeFile(file2)
+	require.NoError(suite.T(), err)
+
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf1, cf2}, WithSharedNetwork("monitoring", "db/backup", "web_app"))
+	require.NoError(suite.T(), err)
+
+	// Verify that shared networks are kept unprefixed
+	assert.Contains(suite.T(), merged.Networks, "backend")
+	assert.NotContains(suite.T(), merged.Networks, "web_backend")
+	assert.Equal(suite.T(), "bridge", merged.Networks["backend"].Driver)
+	assert.Contains(suite.T(), merged.Networks, "monitoring")
+
+	// Verify that services are attached to the shared networks
+	app := merged.Services["web_app"]
+	assert.Contains(suite.T(), app.Networks, "backend")
+	assert.Contains(suite.T(), app.Networks, "web_default")
+	assert.Contains(suite.T(), app.Networks, "monitoring")
+
+	postgres := merged.Services["db_postgres"]
+	assert.Contains(suite.T(), postgres.Networks, "backend")
+	assert.Contains(suite.T(), postgres.Networks, "db_default")
+
+	backup := merged.Services["db_backup"]
+	assert.Contains(suite.T(), backup.Networks, "monitoring")
+	assert.NotContains(suite.T(), backup.Networks, "backend")
+}
+
+// TestMergeComposeFilesWithUnknownSharedService tests attaching a missing service to a shared network
+func (suite *MergeTestSuite) TestMergeComposeFilesWithUnknownSharedService() {
+	testFile := filepath.Join(suite.tmpDir, "web", "docker-compose.yml")
+	err := os.MkdirAll(filepath.Dir(testFile), 0755)
+	require.NoError(suite.T(), err)
+	err = os.WriteFile(testFile, []byte(`
+services:
+  app:
+    image: nginx
+`), 0644)
+	require.NoError(suite.T(), err)
+
+	cf, err := NewComposeFile(testFile)
+	require.NoError(suite.T(), err)
+
+	_, _, err = MergeComposeFiles([]*ComposeFile{cf}, WithSharedNetwork("backend", "db/postgres"))
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "service db_postgres attached to shared network backend does not exist")
+}
+
 // TestMergeComposeFilesWithPortConflicts tests merging compose files with port conflict resolution
 func (suite *MergeTestSuite) TestMergeComposeFilesWithPortConflicts() {
 	// Create the first compose file
@@ -366,7 +1065,7 @@ services:
 	cf2, err := NewComposeFile(file2)
 	require.NoError(suite.T(), err)
 
-	merged, err := MergeComposeFiles([]*ComposeFile{cf1, cf2})
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf1, cf2})
 	require.NoError(suite.T(), err)
 
 	// Verify that services from both files are present with correct prefixes
@@ -394,6 +1093,324 @@ services:
 	assert.Equal(suite.T(), "5432", folder2Postgres.Ports[0].Published)
 }
 
+// TestMergeComposeFilesWithPortProbe tests that ports in use on the host are skipped and reported
+func (suite *MergeTestSuite) TestMergeComposeFilesWithPortProbe() {
+	var files []*ComposeFile
+	for _, dir := range []string{"web", "api"} {
+		file := filepath.Join(suite.tmpDir, dir, "docker-compose.yml")
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		require.NoError(suite.T(), err)
+		err = os.WriteFile(file, []byte(`
+services:
+  app:
+    image: nginx
+    ports: ["80:80"]
+`), 0644)
+		require.NoError(suite.T(), err)
+		cf, err := NewComposeFile(file)
+		require.NoError(suite.T(), err)
+		files = append(files, cf)
+	}
+
+	merged, report, err := MergeComposeFiles(files, WithPortProbe(busyPorts{180: true}))
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), "80", merged.Services["web_app"].Ports[0].Published)
+	assert.Equal(suite.T(), "280", merged.Services["api_app"].Ports[0].Published)
+	assert.Equal(suite.T(), []AvoidedPort{
+		{Service: "api_app", Binding: PortBinding{Port: 180, Protocol: "tcp"}},
+	}, report.AvoidedPorts)
+}
+
+// writePortStacks writes a stack per directory publishing port 80, with the given x-qec settings
+func (suite *MergeTestSuite) writePortStacks(extensions map[string]string, dirs ...string) []*ComposeFile {
+	var files []*ComposeFile
+	for _, dir := range dirs {
+		file := filepath.Join(suite.tmpDir, dir, "docker-compose.yml")
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		require.NoError(suite.T(), err)
+		err = os.WriteFile(file, []byte(extensions[dir]+`
+services:
+  app:
+    image: nginx
+    ports: ["80:80"]
+`), 0644)
+		require.NoError(suite.T(), err)
+		cf, err := NewComposeFile(file)
+		require.NoError(suite.T(), err)
+		files = append(files, cf)
+	}
+	return files
+}
+
+// TestMergeComposeFilesWithPortStrategy tests selecting port strategies by option and configuration
+func (suite *MergeTestSuite) TestMergeComposeFilesWithPortStrategy() {
+	// Strategies given as options
+	merged, _, err := MergeComposeFiles(suite.writePortStacks(nil, "web", "api"), WithPortStrategy(EphemeralStrategy{}))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "80", merged.Services["web_app"].Ports[0].Published)
+	assert.Equal(suite.T(), "", merged.Services["api_app"].Ports[0].Published)
+
+	merged, _, err = MergeComposeFiles(suite.writePortStacks(nil, "web", "api"), WithPortStrategy(NextFreeStrategy{From: 30000, To: 30010}))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "30000", merged.Services["api_app"].Ports[0].Published)
+
+	// Strategies configured by the files
+	extensions := map[string]string{
+		"web": "x-qec:\n  ports:\n    strategy: stack-base\n    base: 10000\n",
+		"api": "x-qec:\n  ports:\n    strategy: stack-base\n    base: 20000\n",
+	}
+	merged, _, err = MergeComposeFiles(suite.writePortStacks(extensions, "web", "api", "db"), WithPortBase("db", 30000))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "80", merged.Services["web_app"].Ports[0].Published)
+	assert.Equal(suite.T(), "20080", merged.Services["api_app"].Ports[0].Published)
+	assert.Equal(suite.T(), "30080", merged.Services["db_app"].Ports[0].Published)
+
+	// Option bases take precedence over configured ones
+	merged, _, err = MergeComposeFiles(suite.writePortStacks(extensions, "web", "api"), WithPortBase("api", 40000))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "40080", merged.Services["api_app"].Ports[0].Published)
+
+	// A file without a strategy follows the others
+	merged, _, err = MergeComposeFiles(suite.writePortStacks(map[string]string{
+		"api": "x-qec:\n  ports:\n    strategy: next-free\n    range: 20000-20010\n",
+	}, "web", "api"))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "20000", merged.Services["api_app"].Ports[0].Published)
+}
+
+// TestMergeComposeFilesWithInvalidPortStrategy tests rejecting inconsistent port strategies
+func (suite *MergeTestSuite) TestMergeComposeFilesWithInvalidPortStrategy() {
+	_, _, err := MergeComposeFiles(suite.writePortStacks(map[string]string{
+		"web": "x-qec:\n  ports:\n    strategy: offset\n",
+		"api": "x-qec:\n  ports:\n    strategy: ephemeral\n",
+	}, "web", "api"))
+	assert.ErrorContains(suite.T(), err, "configure different port strategies")
+
+	_, _, err = MergeComposeFiles(suite.writePortStacks(map[string]string{
+		"web": "x-qec:\n  ports:\n    strategy: random\n",
+	}, "web", "api"))
+	assert.ErrorContains(suite.T(), err, `invalid x-qec.ports`)
+
+	_, _, err = MergeComposeFiles(suite.writePortStacks(nil, "web", "api"), WithPortStrategy(StackBaseStrategy{}), WithPortBase("db", 30000))
+	assert.ErrorContains(suite.T(), err, "port base db=30000 refers to unknown stack db")
+
+	_, _, err = MergeComposeFiles(suite.writePortStacks(nil, "web", "api"), WithPortBase("api", 30000))
+	assert.ErrorContains(suite.T(), err, "port bases only apply to the stack-base port strategy")
+
+	// Conflicting stacks without a base cannot be resolved
+	_, _, err = MergeComposeFiles(suite.writePortStacks(nil, "web", "api"), WithPortStrategy(StackBaseStrategy{}))
+	assert.ErrorContains(suite.T(), err, "no port base set for stack api")
+}
+
+// TestMergeComposeFilesWithProjectName tests naming the merged project
+func (suite *MergeTestSuite) TestMergeComposeFilesWithProjectName() {
+	testFile := filepath.Join(suite.tmpDir, "web", "docker-compose.yml")
+	err := os.MkdirAll(filepath.Dir(testFile), 0755)
+	require.NoError(suite.T(), err)
+	err = os.WriteFile(testFile, []byte(`
+services:
+  app:
+    image: nginx
+`), 0644)
+	require.NoError(suite.T(), err)
+
+	// Without a name the first file's project name is kept
+	cf, err := NewComposeFile(testFile)
+	require.NoError(suite.T(), err)
+	merged, _, err := MergeComposeFiles([]*ComposeFile{cf})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "web", merged.Name)
+
+	cf, err = NewComposeFile(testFile)
+	require.NoError(suite.T(), err)
+	merged, _, err = MergeComposeFiles([]*ComposeFile{cf}, WithProjectName("shop"))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "shop", merged.Name)
+
+	// Names docker compose would reject are refused
+	cf, err = NewComposeFile(testFile)
+	require.NoError(suite.T(), err)
+	_, _, err = MergeComposeFiles([]*ComposeFile{cf}, WithProjectName("My Shop"))
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "invalid project name")
+}
+
+// writeDependencyStacks writes a db stack and a web stack depending on it with x-qec-depends-on
+func (suite *MergeTestSuite) writeDependencyStacks(dependsOn string) []*ComposeFile {
+	contents := map[string]string{
+		"db": `
+services:
+  postgres:
+    image: postgres
+  debug:
+    image: busybox
+    profiles: [debug]
+`,
+		"web": `
+services:
+  api:
+    image: node
+    depends_on:
+      cache:
+        condition: service_healthy
+    x-qec-depends-on:` + dependsOn + `
+  cache:
+    image: redis
+`,
+	}
+
+	var files []*ComposeFile
+	for _, dir := range []string{"db", "web"} {
+		file := filepath.Join(suite.tmpDir, dir, "docker-compose.yml")
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		require.NoError(suite.T(), err)
+		err = os.WriteFile(file, []byte(contents[dir]), 0644)
+		require.NoError(suite.T(), err)
+		cf, err := NewComposeFile(file)
+		require.NoError(suite.T(), err)
+		files = append(files, cf)
+	}
+	return files
+}
+
+// TestMergeComposeFilesWithCrossStackDependencies tests depending on services of other stacks
+func (suite *MergeTestSuite) TestMergeComposeFilesWithCrossStackDependencies() {
+	files := suite.writeDependencyStacks(`
+      db/postgres:
+        condition: service_healthy
+        restart: true
+      db/debug:
+        required: false
+      cache: {}`)
+
+	merged, _, err := MergeComposeFiles(files)
+	require.NoError(suite.T(), err)
+
+	api := merged.Services["web_api"]
+	assert.Equal(suite.T(), types.DependsOnConfig{
+		"db_postgres": {Condition: types.ServiceConditionHealthy, Restart: true, Required: true},
+		"web_cache":   {Condition: types.ServiceConditionStarted, Required: true},
+	}, api.DependsOn)
+
+	// The extension is not written to the merged file
+	assert.NotContains(suite.T(), api.Extensions, DependsOnKey)
+}
+
+// TestMergeComposeFilesWithDependencyList tests the list form of x-qec-depends-on
+func (suite *MergeTestSuite) TestMergeComposeFilesWithDependencyList() {
+	files := suite.writeDependencyStacks(` [db/postgres]`)
+
+	merged, _, err := MergeComposeFiles(files)
+	require.NoError(suite.T(), err)
+
+	api := merged.Services["web_api"]
+	assert.Equal(suite.T(), types.ServiceDependency{Condition: types.ServiceConditionStarted, Required: true}, api.DependsOn["db_postgres"])
+	assert.Equal(suite.T(), types.ServiceConditionHealthy, api.DependsOn["web_cache"].Condition)
+}
+
+// TestMergeComposeFilesWithUndefinedDependency tests that required dependencies must exist
+func (suite *MergeTestSuite) TestMergeComposeFilesWithUndefinedDependency() {
+	files := suite.writeDependencyStacks(` [db/debug]`)
+
+	_, _, err := MergeComposeFiles(files)
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "service web_api depends on undefined service db_debug")
+}
+
+// TestMergeComposeFilesLeavesInputsUnchanged tests that merging works on copies of the files,
+// so that merging the same files again gives the same result
+func (suite *MergeTestSuite) TestMergeComposeFilesLeavesInputsUnchanged() {
+	files := suite.writeDependencyStacks(" [db/postgres]")
+
+	// Publish the same port in both stacks so that merging has to shift one of them
+	port := []types.ServicePortConfig{{Target: 5432, Published: "5432", Protocol: "tcp"}}
+	for _, cf := range files {
+		for name, service := range cf.Project.Services {
+			service.Ports = port
+			cf.Project.Services[name] = service
+		}
+	}
+
+	var before []*types.Project
+	for _, cf := range files {
+		project, err := copyProject(cf.Project)
+		require.NoError(suite.T(), err)
+		before = append(before, project)
+	}
+
+	merged, report, err := MergeComposeFiles(files)
+	require.NoError(suite.T(), err)
+	assert.Contains(suite.T(), merged.Services, "web_api")
+
+	// Verify that the input files keep their original names, dependencies and ports
+	for i, cf := range files {
+		assert.Equal(suite.T(), before[i], cf.Project)
+		assert.Nil(suite.T(), cf.sharedNetworks)
+	}
+
+	// Verify that merging again gives the same project and report
+	again, againReport, err := MergeComposeFiles(files)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), merged, again)
+	assert.Equal(suite.T(), report, againReport)
+
+	// Verify that the merged project does not share state with the inputs
+	service := merged.Services["web_api"]
+	service.Image = "changed"
+	merged.Services["web_api"] = service
+	assert.Equal(suite.T(), "node", again.Services["web_api"].Image)
+}
+
+// TestMergeComposeFilesReport tests the report of changes made while merging
+func (suite *MergeTestSuite) TestMergeComposeFilesReport() {
+	folder := filepath.Join(suite.tmpDir, "web")
+	err := os.MkdirAll(folder, 0755)
+	require.NoError(suite.T(), err)
+	file := filepath.Join(folder, "docker-compose.yml")
+	err = os.WriteFile(file, []byte(`
+services:
+  app:
+    build: ./app
+    environment:
+      CACHE_HOST: cache
+  cache:
+    image: redis
+`), 0644)
+	require.NoError(suite.T(), err)
+	err = os.MkdirAll(filepath.Join(folder, "app"), 0755)
+	require.NoError(suite.T(), err)
+
+	cf, err := NewComposeFile(file)
+	require.NoError(suite.T(), err)
+
+	_, report, err := MergeComposeFiles([]*ComposeFile{cf})
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), map[string]string{file: "web"}, report.Prefixes)
+	assert.Equal(suite.T(), []PathChange{
+		{Resource: "service app", Field: "build.dockerfile", From: "Dockerfile", To: filepath.Join(folder, "app", "Dockerfile")},
+	}, report.PathChanges[file])
+	assert.Equal(suite.T(), []HostnameRewrite{
+		{Service: "web_app", Field: "environment.CACHE_HOST", From: "cache", To: "web_cache"},
+	}, report.HostnameRewrites)
+}
+
+// TestSortReport tests that report entries are ordered field by field
+func (suite *MergeTestSuite) TestSortReport() {
+	changes := []PathChange{
+		{Resource: "service web", Field: "volumes[0].source"},
+		{Resource: "config nginx", Field: "file"},
+		{Resource: "service web", Field: "build.context"},
+	}
+	sortReport(changes, func(c PathChange) []string { return []string{c.Resource, c.Field} })
+	assert.Equal(suite.T(), []PathChange{
+		{Resource: "config nginx", Field: "file"},
+		{Resource: "service web", Field: "build.context"},
+		{Resource: "service web", Field: "volumes[0].source"},
+	}, changes)
+}
+
 // Run the test suite
 func TestMergeTestSuite(t *testing.T) {
 	suite.Run(t, new(MergeTestSuite))
@@ -0,0 +1,41 @@
+package compose
+
+import "sort"
+
+// declaredServices returns the names of the services in the order the files declare them.
+// Services declared again by a later file keep their first position.
+func declaredServices(sources []composeSource) []string {
+	var names []string
+	for _, source := range sources {
+		for _, name := range source.services {
+			names = appendUnique(names, name)
+		}
+	}
+	return names
+}
+
+// serviceOrder returns the merged names of the files' services, ordered by the position of
+// their file and then by declaration within it. It decides which services keep their ports
+// when ports conflict.
+func serviceOrder(files []*ComposeFile) []string {
+	var order []string
+	for _, cf := range files {
+		prefix := cf.prefix()
+		for _, name := range cf.serviceOrder {
+			if _, ok := cf.Project.Services[prefix+"_"+name]; ok {
+				order = appendUnique(order, prefix+"_"+name)
+			}
+		}
+
+		// Services the declarations did not list, e.g. with interpolated names, come last
+		var rest []string
+		for name := range cf.Project.Services {
+			rest = append(rest, name)
+		}
+		sort.Strings(rest)
+		for _, name := range rest {
+			order = appendUnique(order, name)
+		}
+	}
+	return order
+}
@@ -0,0 +1,120 @@
+package compose
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// OrderTestSuite defines the test suite for service ordering
+type OrderTestSuite struct {
+	suite.Suite
+	tmpDir string
+}
+
+// SetupTest runs before each test
+func (suite *OrderTestSuite) SetupTest() {
+	suite.tmpDir = suite.T().TempDir()
+}
+
+// TestDeclaredServices tests that services are listed in declaration order across overrides
+func (suite *OrderTestSuite) TestDeclaredServices() {
+	base := writeFile(suite.T(), suite.tmpDir, "web/docker-compose.yml", `
+services:
+  zeta:
+    image: nginx
+  alpha:
+    image: redis
+  mid:
+    image: postgres
+`)
+	override := writeFile(suite.T(), suite.tmpDir, "web/docker-compose.override.yml", `
+services:
+  alpha:
+    environment:
+      DEBUG: "1"
+  extra:
+    image: busybox
+`)
+	empty := writeFile(suite.T(), suite.tmpDir, "web/empty.yml", `
+volumes:
+  data:
+`)
+
+	sources, err := readSources([]string{base, override, empty})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"zeta", "alpha", "mid", "extra"}, declaredServices(sources))
+
+	cf, err := NewComposeFile(base, override)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"zeta", "alpha", "mid", "extra"}, cf.serviceOrder)
+}
+
+// TestServiceOrder tests that merged services are ordered by file and then by declaration
+func (suite *OrderTestSuite) TestServiceOrder() {
+	web := writeFile(suite.T(), suite.tmpDir, "web/docker-compose.yml", `
+services:
+  web:
+    image: nginx
+  api:
+    image: node
+`)
+	db := writeFile(suite.T(), suite.tmpDir, "db/docker-compose.yml", `
+services:
+  postgres:
+    image: postgres
+  backup:
+    image: busybox
+`)
+
+	var files []*ComposeFile
+	for _, path := range []string{web, db} {
+		cf, err := NewComposeFile(path)
+		require.NoError(suite.T(), err)
+		require.NoError(suite.T(), cf.prefixResourceNames(cf.prefix(), logrus.New().WithField("test", true)))
+		files = append(files, cf)
+	}
+
+	assert.Equal(suite.T(), []string{"web_web", "web_api", "db_postgres", "db_backup"}, serviceOrder(files))
+}
+
+// TestMergeKeepsPortsOfFirstFile tests that the first file keeps its ports whatever its prefix
+func (suite *OrderTestSuite) TestMergeKeepsPortsOfFirstFile() {
+	content := `
+services:
+  proxy:
+    image: nginx
+    ports: ["80:80"]
+  app:
+    image: nginx
+    ports: ["80:8080"]
+`
+	web := writeFile(suite.T(), suite.tmpDir, "web/docker-compose.yml", content)
+	api := writeFile(suite.T(), suite.tmpDir, "api/docker-compose.yml", content)
+
+	for i := 0; i < 3; i++ {
+		var files []*ComposeFile
+		for _, path := range []string{web, api} {
+			cf, err := NewComposeFile(path)
+			require.NoError(suite.T(), err)
+			files = append(files, cf)
+		}
+
+		merged, _, err := MergeComposeFiles(files)
+		require.NoError(suite.T(), err)
+
+		// Ports follow the -f order and then the declaration order, not the service names
+		assert.Equal(suite.T(), "80", merged.Services["web_proxy"].Ports[0].Published)
+		assert.Equal(suite.T(), "180", merged.Services["web_app"].Ports[0].Published)
+		assert.Equal(suite.T(), "280", merged.Services["api_proxy"].Ports[0].Published)
+		assert.Equal(suite.T(), "380", merged.Services["api_app"].Ports[0].Published)
+	}
+}
+
+// TestOrderTestSuite runs the test suite
+func TestOrderTestSuite(t *testing.T) {
+	suite.Run(t, new(OrderTestSuite))
+}
@@ -0,0 +1,186 @@
+package compose
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// PathChange records a path rewritten by path normalization
+type PathChange struct {
+	Resource string // Resource holding the path, e.g. "service app" or "config nginx"
+	Field    string // Field holding the path, e.g. "build.context"
+	From     string // Original path
+	To       string // Rewritten path
+}
+
+// String returns a human-readable description of the change
+func (c PathChange) String() string {
+	return fmt.Sprintf("%s %s: %q -> %q", c.Resource, c.Field, c.From, c.To)
+}
+
+// projectPath is a local path held by a field of a project
+type projectPath struct {
+	resource string  // Resource holding the path, e.g. "service app"
+	field    string  // Field holding the path, e.g. "build.context"
+	base     string  // Path the value is relative to instead of the file, e.g. the build context for dockerfiles
+	path     *string // The path itself, updated in place
+}
+
+// visitPaths calls visit for every local path of the project. Remote build contexts, image and
+// service contexts and named volumes are skipped. Build secrets refer to top-level secrets,
+// whose files are visited with them.
+func visitPaths(project *types.Project, visit func(p projectPath)) {
+	for name, service := range project.Services {
+		resource := "service " + name
+		field := func(field string, path *string) projectPath {
+			return projectPath{resource: resource, field: field, path: path}
+		}
+
+		if build := service.Build; build != nil {
+			if !isRemoteContext(build.Context) {
+				// Dockerfiles resolve against the build context, visit them while it is unchanged
+				if build.DockerfileInline == "" {
+					dockerfile := field("build.dockerfile", &build.Dockerfile)
+					dockerfile.base = build.Context
+					visit(dockerfile)
+				}
+				visit(field("build.context", &build.Context))
+			}
+			for key, context := range build.AdditionalContexts {
+				if isRemoteContext(context) {
+					continue
+				}
+				visit(field("build.additional_contexts."+key, &context))
+				build.AdditionalContexts[key] = context
+			}
+			for i := range build.SSH {
+				visit(field("build.ssh."+build.SSH[i].ID, &build.SSH[i].Path))
+			}
+		}
+
+		for i := range service.EnvFiles {
+			visit(field(fmt.Sprintf("env_file[%d]", i), &service.EnvFiles[i].Path))
+		}
+		for i := range service.LabelFiles {
+			visit(field(fmt.Sprintf("label_file[%d]", i), &service.LabelFiles[i]))
+		}
+
+		for i := range service.Volumes {
+			if service.Volumes[i].Type == types.VolumeTypeBind {
+				visit(field(fmt.Sprintf("volumes[%d].source", i), &service.Volumes[i].Source))
+			}
+		}
+
+		if service.Extends != nil {
+			visit(field("extends.file", &service.Extends.File))
+		}
+
+		if service.Develop != nil {
+			for i := range service.Develop.Watch {
+				visit(field(fmt.Sprintf("develop.watch[%d].path", i), &service.Develop.Watch[i].Path))
+			}
+		}
+
+		project.Services[name] = service
+	}
+
+	for name, volume := range project.Volumes {
+		// Local volumes bound to a host directory
+		if device, ok := volume.DriverOpts["device"]; ok && volume.DriverOpts["o"] == "bind" {
+			visit(projectPath{resource: "volume " + name, field: "driver_opts.device", path: &device})
+			volume.DriverOpts["device"] = device
+		}
+	}
+
+	for name, config := range project.Configs {
+		visit(projectPath{resource: "config " + name, field: "file", path: &config.File})
+		project.Configs[name] = config
+	}
+
+	for name, secret := range project.Secrets {
+		visit(projectPath{resource: "secret " + name, field: "file", path: &secret.File})
+		project.Secrets[name] = secret
+	}
+}
+
+// normalizePaths makes every relative path of the project absolute against the file's base
+// directory. The loader already resolves paths inherited through extends against the file
+// declaring them.
+func (cf *ComposeFile) normalizePaths() []PathChange {
+	var changes []PathChange
+
+	visitPaths(cf.Project, func(p projectPath) {
+		if *p.path == "" || filepath.IsAbs(*p.path) {
+			return
+		}
+		dir := cf.BaseDir
+		if p.base != "" {
+			if filepath.IsAbs(p.base) {
+				dir = p.base
+			} else {
+				dir = filepath.Join(dir, p.base)
+			}
+		}
+		abs := filepath.Join(dir, *p.path)
+		changes = append(changes, PathChange{Resource: p.resource, Field: p.field, From: *p.path, To: abs})
+		*p.path = abs
+	})
+
+	sortReport(changes, func(c PathChange) []string { return []string{c.Resource, c.Field} })
+	return changes
+}
+
+// relativizePaths returns a copy of the project with absolute paths made relative to dir, so
+// that a compose file written to dir can be moved along with the stacks it refers to
+func relativizePaths(project *types.Project, dir string) (*types.Project, []PathChange, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get absolute path for %s: %w", dir, err)
+	}
+
+	copied, err := copyProject(project)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to copy project: %w", err)
+	}
+
+	var changes []PathChange
+	visitPaths(copied, func(p projectPath) {
+		base := absDir
+		if p.base != "" {
+			base = p.base
+		}
+		if !filepath.IsAbs(*p.path) || !filepath.IsAbs(base) {
+			return
+		}
+		rel, err := filepath.Rel(base, *p.path)
+		if err != nil {
+			return
+		}
+		// Keep a leading ./ so that bind sources are never mistaken for named volumes
+		if rel != "." && !strings.HasPrefix(rel, "..") {
+			rel = "." + string(filepath.Separator) + rel
+		}
+		changes = append(changes, PathChange{Resource: p.resource, Field: p.field, From: *p.path, To: rel})
+		*p.path = rel
+	})
+
+	sortReport(changes, func(c PathChange) []string { return []string{c.Resource, c.Field} })
+	return copied, changes, nil
+}
+
+// isRemoteContext reports whether a build context is a URL, Git reference or service
+// reference rather than a local directory
+func isRemoteContext(context string) bool {
+	if strings.Contains(context, "://") {
+		return true
+	}
+	for _, prefix := range []string{"github.com/", "git@", "service:"} {
+		if strings.HasPrefix(context, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package compose
+
+import (
+	"testing"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// PathsTestSuite defines the test suite for path normalization
+type PathsTestSuite struct {
+	suite.Suite
+}
+
+// newComposeFile returns a compose file in /stacks/web holding relative paths in every path field
+func (suite *PathsTestSuite) newComposeFile() *ComposeFile {
+	return &ComposeFile{
+		Path:    "/stacks/web/docker-compose.yml",
+		BaseDir: "/stacks/web",
+		Project: &types.Project{
+			Name: "web",
+			Services: types.Services{
+				"app": {
+					Name: "app",
+					Build: &types.BuildConfig{
+						Context:            "app",
+						Dockerfile:         "../docker/Dockerfile",
+						AdditionalContexts: types.Mapping{"assets": "../assets", "base": "docker-image://alpine", "api": "service:api"},
+						SSH:                types.SSHConfig{{ID: "deploy", Path: "keys/deploy"}, {ID: "default"}},
+					},
+					EnvFiles:   []types.EnvFile{{Path: ".env"}, {Path: "/etc/app.env"}},
+					LabelFiles: []string{"labels.env"},
+					Volumes: []types.ServiceVolumeConfig{
+						{Type: types.VolumeTypeBind, Source: "./data", Target: "/data"},
+						{Type: types.VolumeTypeVolume, Source: "cache", Target: "/cache"},
+					},
+					Extends: &types.ExtendsConfig{File: "../common/base.yml", Service: "base"},
+					Develop: &types.DevelopConfig{Watch: []types.Trigger{{Path: "src", Action: types.WatchActionSync}}},
+				},
+				"remote": {
+					Name:  "remote",
+					Build: &types.BuildConfig{Context: "https://github.com/example/app.git", Dockerfile: "Dockerfile"},
+				},
+			},
+			Volumes: types.Volumes{
+				"logs":    {DriverOpts: types.Options{"type": "none", "o": "bind", "device": "logs"}},
+				"archive": {DriverOpts: types.Options{"type": "none", "o": "bind,ro", "device": "archive"}},
+			},
+			Configs: types.Configs{
+				"nginx": {File: "nginx.conf"},
+			},
+			Secrets: types.Secrets{
+				"token": {File: "secrets/token"},
+				"env":   {Environment: "TOKEN"},
+			},
+		},
+	}
+}
+
+// TestNormalizePaths tests that every path-bearing field is made absolute
+func (suite *PathsTestSuite) TestNormalizePaths() {
+	cf := suite.newComposeFile()
+	cf.normalizePaths()
+
+	app := cf.Project.Services["app"]
+	assert.Equal(suite.T(), "/stacks/web/app", app.Build.Context)
+	assert.Equal(suite.T(), "/stacks/web/docker/Dockerfile", app.Build.Dockerfile)
+	assert.Equal(suite.T(), "/stacks/assets", app.Build.AdditionalContexts["assets"])
+	assert.Equal(suite.T(), "docker-image://alpine", app.Build.AdditionalContexts["base"])
+	assert.Equal(suite.T(), "service:api", app.Build.AdditionalContexts["api"])
+	assert.Equal(suite.T(), "/stacks/web/keys/deploy", app.Build.SSH[0].Path)
+	assert.Equal(suite.T(), "", app.Build.SSH[1].Path)
+	assert.Equal(suite.T(), "/stacks/web/.env", app.EnvFiles[0].Path)
+	assert.Equal(suite.T(), "/etc/app.env", app.EnvFiles[1].Path)
+	assert.Equal(suite.T(), "/stacks/web/labels.env", app.LabelFiles[0])
+	assert.Equal(suite.T(), "/stacks/web/data", app.Volumes[0].Source)
+	assert.Equal(suite.T(), "cache", app.Volumes[1].Source)
+	assert.Equal(suite.T(), "/stacks/common/base.yml", app.Extends.File)
+	assert.Equal(suite.T(), "/stacks/web/src", app.Develop.Watch[0].Path)
+
+	// Remote build contexts and their dockerfiles are left untouched
+	remote := cf.Project.Services["remote"]
+	assert.Equal(suite.T(), "https://github.com/example/app.git", remote.Build.Context)
+	assert.Equal(suite.T(), "Dockerfile", remote.Build.Dockerfile)
+
+	assert.Equal(suite.T(), "/stacks/web/logs", cf.Project.Volumes["logs"].DriverOpts["device"])
+	// Only plain bind devices are paths; the loader leaves other mount options alone
+	assert.Equal(suite.T(), "archive", cf.Project.Volumes["archive"].DriverOpts["device"])
+	assert.Equal(suite.T(), "/stacks/web/nginx.conf", cf.Project.Configs["nginx"].File)
+	assert.Equal(suite.T(), "/stacks/web/secrets/token", cf.Project.Secrets["token"].File)
+	assert.Equal(suite.T(), "", cf.Project.Secrets["env"].File)
+}
+
+// TestNormalizePathsReport tests that every changed path is reported in a stable order
+func (suite *PathsTestSuite) TestNormalizePathsReport() {
+	cf := suite.newComposeFile()
+	changes := cf.normalizePaths()
+
+	var fields []string
+	for _, change := range changes {
+		fields = append(fields, change.Resource+" "+change.Field)
+	}
+	assert.Equal(suite.T(), []string{
+		"config nginx file",
+		"secret token file",
+		"service app build.additional_contexts.assets",
+		"service app build.context",
+		"service app build.dockerfile",
+		"service app build.ssh.deploy",
+		"service app develop.watch[0].path",
+		"service app env_file[0]",
+		"service app extends.file",
+		"service app label_file[0]",
+		"service app volumes[0].source",
+		"volume logs driver_opts.device",
+	}, fields)
+
+	require.NotEmpty(suite.T(), changes)
+	assert.Equal(suite.T(), `config nginx file: "nginx.conf" -> "/stacks/web/nginx.conf"`, changes[0].String())
+
+	// A second pass has nothing left to change
+	assert.Empty(suite.T(), cf.normalizePaths())
+}
+
+// TestRelativizePaths tests that absolute paths are made relative to the merged file's directory
+func (suite *PathsTestSuite) TestRelativizePaths() {
+	cf := suite.newComposeFile()
+	cf.normalizePaths()
+
+	relative, changes, err := relativizePaths(cf.Project, "/stacks")
+	require.NoError(suite.T(), err)
+	assert.NotEmpty(suite.T(), changes)
+
+	app := relative.Services["app"]
+	assert.Equal(suite.T(), "./web/app", app.Build.Context)
+	assert.Equal(suite.T(), "../docker/Dockerfile", app.Build.Dockerfile)
+	assert.Equal(suite.T(), "./assets", app.Build.AdditionalContexts["assets"])
+	assert.Equal(suite.T(), "docker-image://alpine", app.Build.AdditionalContexts["base"])
+	assert.Equal(suite.T(), "./web/.env", app.EnvFiles[0].Path)
+	assert.Equal(suite.T(), "../etc/app.env", app.EnvFiles[1].Path)
+	assert.Equal(suite.T(), "./web/labels.env", app.LabelFiles[0])
+	assert.Equal(suite.T(), "./web/data", app.Volumes[0].Source)
+	assert.Equal(suite.T(), "cache", app.Volumes[1].Source)
+	assert.Equal(suite.T(), "./web/nginx.conf", relative.Configs["nginx"].File)
+	assert.Equal(suite.T(), "./web/logs", relative.Volumes["logs"].DriverOpts["device"])
+	assert.Equal(suite.T(), "archive", relative.Volumes["archive"].DriverOpts["device"])
+	assert.Equal(suite.T(), "https://github.com/example/app.git", relative.Services["remote"].Build.Context)
+
+	// Verify that the original project keeps its absolute paths
+	assert.Equal(suite.T(), "/stacks/web/app", cf.Project.Services["app"].Build.Context)
+	assert.Equal(suite.T(), "/stacks/web/data", cf.Project.Services["app"].Volumes[0].Source)
+	assert.Equal(suite.T(), "/stacks/assets", cf.Project.Services["app"].Build.AdditionalContexts["assets"])
+	assert.Equal(suite.T(), "/stacks/web/logs", cf.Project.Volumes["logs"].DriverOpts["device"])
+	assert.Equal(suite.T(), "/stacks/web/nginx.conf", cf.Project.Configs["nginx"].File)
+}
+
+// Run the test suite
+func TestPathsTestSuite(t *testing.T) {
+	suite.Run(t, new(PathsTestSuite))
+}
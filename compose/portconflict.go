@@ -2,140 +2,422 @@ package compose
 
 import (
 	"fmt"
+	"net"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/compose-spec/compose-go/v2/types"
 	"github.com/sirupsen/logrus"
 )
 
-// PortConflict represents a port mapping conflict between services
+// PortBinding is a host port, or a range of host ports, published by a service
+type PortBinding struct {
+	HostIP   string // Host address the port is bound to, empty for every address
+	Port     uint32 // First port of the binding
+	EndPort  uint32 // Last port of a range, zero for a single port
+	Protocol string // "tcp" or "udp"
+}
+
+// last returns the last port of the binding
+func (b PortBinding) last() uint32 {
+	if b.EndPort > b.Port {
+		return b.EndPort
+	}
+	return b.Port
+}
+
+// published returns the binding's ports in the form of a published port, e.g. "80" or
+// "8000-8010", empty when the binding has no host port
+func (b PortBinding) published() string {
+	if b.Port == 0 {
+		return ""
+	}
+	port := strconv.FormatUint(uint64(b.Port), 10)
+	if b.last() == b.Port {
+		return port
+	}
+	return port + "-" + strconv.FormatUint(uint64(b.last()), 10)
+}
+
+// shift returns the binding moved by delta ports, keeping the width of ranges
+func (b PortBinding) shift(delta uint32) PortBinding {
+	shifted := b
+	shifted.Port += delta
+	if b.EndPort != 0 {
+		shifted.EndPort += delta
+	}
+	return shifted
+}
+
+// String returns the binding as HOST_IP:PORT/PROTOCOL, leaving out the address when it is every address
+func (b PortBinding) String() string {
+	if b.HostIP == "" {
+		return fmt.Sprintf("%s/%s", b.published(), b.Protocol)
+	}
+	return fmt.Sprintf("%s/%s", net.JoinHostPort(b.HostIP, b.published()), b.Protocol)
+}
+
+// overlaps reports whether both bindings claim a common host port. A binding to every
+// address overlaps bindings to any single address.
+func (b PortBinding) overlaps(other PortBinding) bool {
+	if b.Protocol != other.Protocol || b.Port > other.last() || other.Port > b.last() {
+		return false
+	}
+	return b.HostIP == "" || other.HostIP == "" || b.HostIP == other.HostIP
+}
+
+// PortConflict represents host ports claimed by several services
 type PortConflict struct {
-	HostPort uint32
+	HostIP   string // Address the services bind the ports to, empty when one of them binds every address
+	HostPort uint32 // First conflicting port
+	EndPort  uint32 // Last port when the conflict spans a range, zero for a single port
+	Protocol string
 	Services []string
 }
 
-// DetectPortConflicts scans through service configurations and identifies host port collisions
-func DetectPortConflicts(services types.Services, logger *logrus.Entry) map[uint32][]string {
-	logger = logger.WithField("function", "DetectPortConflicts")
-
-	// Map to store port -> service name mappings
-	portMap := make(map[uint32][]string)
+// String returns the conflicting ports as HOST_IP:PORT/PROTOCOL
+func (c PortConflict) String() string {
+	return PortBinding{HostIP: c.HostIP, Port: c.HostPort, EndPort: c.EndPort, Protocol: c.Protocol}.String()
+}
 
-	// Iterate through all services
-	for name, service := range services {
-		// Skip services without port mappings
-		if len(service.Ports) == 0 {
-			continue
+// parsePortBinding returns the host binding of a published port, either a single port or a
+// range such as "8000-8010". Wildcard addresses are normalized to an empty HostIP and a missing
+// protocol to tcp.
+func parsePortBinding(port types.ServicePortConfig) (PortBinding, error) {
+	first, last, isRange := strings.Cut(port.Published, "-")
+	hostPort, err := strconv.ParseUint(first, 10, 32)
+	if err != nil {
+		return PortBinding{}, err
+	}
+	var endPort uint64
+	if isRange {
+		endPort, err = strconv.ParseUint(last, 10, 32)
+		if err != nil {
+			return PortBinding{}, err
+		}
+		if endPort < hostPort {
+			return PortBinding{}, fmt.Errorf("invalid port range %s", port.Published)
+		}
+		if endPort == hostPort {
+			endPort = 0
 		}
+	}
+
+	hostIP := port.HostIP
+	switch hostIP {
+	case "0.0.0.0", "::", "[::]":
+		hostIP = ""
+	}
+
+	protocol := strings.ToLower(port.Protocol)
+	if protocol == "" {
+		protocol = "tcp"
+	}
+
+	return PortBinding{HostIP: hostIP, Port: uint32(hostPort), EndPort: uint32(endPort), Protocol: protocol}, nil
+}
 
-		// Check each port mapping
-		for _, port := range service.Ports {
+// serviceBinding is a published port of a service
+type serviceBinding struct {
+	service string
+	index   int // Position of the port in the service's ports
+	binding PortBinding
+}
+
+// portConflictGroup is a conflict along with the bindings involved in it
+type portConflictGroup struct {
+	conflict PortConflict
+	bindings []serviceBinding
+}
+
+// publishedBindings returns the published ports of every service
+func publishedBindings(services types.Services, logger *logrus.Entry) []serviceBinding {
+	var bindings []serviceBinding
+	for name, service := range services {
+		for i, port := range service.Ports {
 			// Skip if no host port is specified (using container port)
 			if port.Published == "" {
 				continue
 			}
 
-			// Parse the published port string to uint32
-			hostPort, err := strconv.ParseUint(port.Published, 10, 32)
+			binding, err := parsePortBinding(port)
 			if err != nil {
 				logger.Warnf("Invalid port format for service %s: %s", name, port.Published)
 				continue
 			}
 
-			// Add service to the port mapping
-			portMap[uint32(hostPort)] = append(portMap[uint32(hostPort)], name)
-			logger.Debugf("Service %s maps to host port %d", name, hostPort)
+			bindings = append(bindings, serviceBinding{service: name, index: i, binding: binding})
+			logger.Debugf("Service %s maps to host port %s", name, binding)
+		}
+	}
+	return bindings
+}
+
+// detectPortConflicts groups overlapping bindings of different services. Bindings are in the
+// same group when they overlap directly or through other bindings, e.g. two single addresses
+// both overlapping a binding to every address. The services of each conflict are listed in
+// the given order, followed by services missing from it in alphabetical order.
+func detectPortConflicts(services types.Services, order []string, logger *logrus.Entry) []portConflictGroup {
+	bindings := publishedBindings(services, logger)
+
+	rank := make(map[string]int, len(order))
+	for i, name := range order {
+		rank[name] = i
+	}
+	before := func(a, b string) bool {
+		rankA, okA := rank[a]
+		rankB, okB := rank[b]
+		if okA != okB {
+			return okA
+		}
+		if okA && rankA != rankB {
+			return rankA < rankB
+		}
+		return a < b
+	}
+
+	// Union overlapping bindings
+	parent := make([]int, len(bindings))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range bindings {
+		for j := i + 1; j < len(bindings); j++ {
+			if bindings[i].binding.overlaps(bindings[j].binding) {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+	members := make(map[int][]serviceBinding)
+	for i, b := range bindings {
+		members[find(i)] = append(members[find(i)], b)
+	}
+
+	// Keep only groups claimed by more than one service
+	var groups []portConflictGroup
+	for _, group := range members {
+		conflict := PortConflict{
+			HostIP:   group[0].binding.HostIP,
+			HostPort: group[0].binding.Port,
+			Protocol: group[0].binding.Protocol,
+		}
+		last := group[0].binding.last()
+		for _, b := range group {
+			conflict.Services = appendUnique(conflict.Services, b.service)
+			if b.binding.HostIP == "" {
+				conflict.HostIP = ""
+			}
+			conflict.HostPort = min(conflict.HostPort, b.binding.Port)
+			last = max(last, b.binding.last())
 		}
+		if len(conflict.Services) < 2 {
+			continue
+		}
+		if last != conflict.HostPort {
+			conflict.EndPort = last
+		}
+
+		// Sort service names and bindings to ensure consistent order
+		sort.Slice(conflict.Services, func(i, j int) bool {
+			return before(conflict.Services[i], conflict.Services[j])
+		})
+		sort.Slice(group, func(i, j int) bool {
+			if group[i].service != group[j].service {
+				return before(group[i].service, group[j].service)
+			}
+			return group[i].index < group[j].index
+		})
+		groups = append(groups, portConflictGroup{conflict: conflict, bindings: group})
 	}
 
-	// Filter out ports with only one service (no conflicts)
-	conflicts := make(map[uint32][]string)
-	for port, serviceNames := range portMap {
-		if len(serviceNames) > 1 {
-			// Sort service names to ensure consistent order
-			sort.Strings(serviceNames)
-			conflicts[port] = serviceNames
-			logger.Warnf("Port conflict detected on port %d between services: %v", port, serviceNames)
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i].conflict, groups[j].conflict
+		if a.HostPort != b.HostPort {
+			return a.HostPort < b.HostPort
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
 		}
+		return a.HostIP < b.HostIP
+	})
+
+	return groups
+}
+
+// DetectPortConflicts scans through service configurations and identifies host port collisions.
+// Ports collide when their ranges share a port and protocol and are bound to the same address,
+// or when one of them is bound to every address.
+func DetectPortConflicts(services types.Services, logger *logrus.Entry) []PortConflict {
+	logger = logger.WithField("function", "DetectPortConflicts")
+
+	var conflicts []PortConflict
+	for _, group := range detectPortConflicts(services, nil, logger) {
+		logger.Warnf("Port conflict detected on port %s between services: %v", group.conflict, group.conflict.Services)
+		conflicts = append(conflicts, group.conflict)
 	}
 
 	return conflicts
 }
 
-// ResolvePortConflicts attempts to resolve port conflicts by applying an offset
+// appendUnique appends name unless the list already holds it
+func appendUnique(names []string, name string) []string {
+	for _, existing := range names {
+		if existing == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
+
+// ResolvePortConflicts attempts to resolve port conflicts by applying an offset. The first
+// service of each conflict in alphabetical order keeps its ports; later services whose
+// bindings overlap an earlier one's are moved by offset times their position in the conflict.
+// Port ranges are moved as a block, keeping their width.
 func ResolvePortConflicts(services types.Services, offset uint32, logger *logrus.Entry) error {
+	_, err := PortResolver{Strategy: OffsetStrategy{Offset: offset}}.Resolve(services, logger)
+	return err
+}
+
+// PortResolver moves conflicting host ports of merged services
+type PortResolver struct {
+	// Strategy picks the ports conflicting bindings move to, an offset of DefaultPortOffset when nil
+	Strategy PortStrategy
+	// Probe checks moved ports on the host so that strategies can skip ports in use. Nil checks nothing.
+	Probe PortProbe
+	// Order lists services by priority: the first service of a conflict keeps its ports.
+	// Services missing from it come last, in alphabetical order.
+	Order []string
+	// Stacks maps merged service names to the prefix of their stack
+	Stacks map[string]string
+}
+
+// Resolve moves the conflicting ports of the services, returning the ports skipped because
+// the probe found them in use
+func (r PortResolver) Resolve(services types.Services, logger *logrus.Entry) ([]AvoidedPort, error) {
 	// Initialize logger for this function
 	logger = logger.WithField("function", "ResolvePortConflicts")
 
+	strategy := r.Strategy
+	if strategy == nil {
+		strategy = OffsetStrategy{Offset: DefaultPortOffset}
+	}
+
 	// First detect all conflicts
-	conflicts := DetectPortConflicts(services, logger)
+	groups := detectPortConflicts(services, r.Order, logger)
 
 	// If no conflicts, we're done
-	if len(conflicts) == 0 {
-		return nil
+	if len(groups) == 0 {
+		return nil, nil
+	}
+	for _, group := range groups {
+		logger.Warnf("Port conflict detected on port %s between services: %v", group.conflict, group.conflict.Services)
 	}
 
-	// Create a map to track used ports after resolution
-	usedPorts := make(map[uint32]bool)
-
-	// Iterate through all services and adjust conflicting ports
-	for name, service := range services {
-		if len(service.Ports) == 0 {
-			continue
+	// Track the bindings in use after resolution, starting with those not in conflict and
+	// those of the services keeping their ports
+	inConflict := make(map[string]map[int]bool)
+	var used []serviceBinding
+	for _, group := range groups {
+		for _, b := range group.bindings {
+			if inConflict[b.service] == nil {
+				inConflict[b.service] = make(map[int]bool)
+			}
+			inConflict[b.service][b.index] = true
+			if b.service == group.conflict.Services[0] {
+				used = append(used, b)
+			}
+		}
+	}
+	for _, b := range publishedBindings(services, logger) {
+		if !inConflict[b.service][b.index] {
+			used = append(used, b)
 		}
+	}
 
-		// Check each port mapping
-		for i := range service.Ports {
-			port := &service.Ports[i]
-			if port.Published == "" {
-				continue
-			}
+	var avoided []AvoidedPort
+	for _, group := range groups {
+		// Original bindings of the services already handled in this conflict
+		var claimed []PortBinding
 
-			// Parse the published port string to uint32
-			hostPort, err := strconv.ParseUint(port.Published, 10, 32)
-			if err != nil {
-				logger.Warnf("Invalid port format for service %s: %s", name, port.Published)
-				continue
-			}
+		for index, name := range group.conflict.Services {
+			service := services[name]
+			var own []PortBinding
+
+			for _, b := range group.bindings {
+				if b.service != name {
+					continue
+				}
+				own = append(own, b.binding)
 
-			// Check if this port is in conflict
-			if conflictingServices, hasConflict := conflicts[uint32(hostPort)]; hasConflict {
-				// Find the service's position in the conflict list
-				serviceIndex := -1
-				for j, s := range conflictingServices {
-					if s == name {
-						serviceIndex = j
+				// Keep the ports unless they overlap a binding of an earlier service
+				moved := false
+				for _, other := range claimed {
+					if other.overlaps(b.binding) {
+						moved = true
 						break
 					}
 				}
-
-				if serviceIndex == -1 {
+				if !moved {
+					if index > 0 {
+						used = append(used, b)
+					}
 					continue
 				}
 
-				// Calculate new port by adding offset * service index
-				newPort := uint32(hostPort) + (offset * uint32(serviceIndex))
-
-				// Check if the new port is already in use
-				if usedPorts[newPort] {
-					return fmt.Errorf("unable to resolve port conflict: port %d is already in use after applying offset", newPort)
+				newBinding, err := strategy.Assign(PortRequest{
+					Service:  name,
+					Stack:    r.Stacks[name],
+					Position: index,
+					Binding:  b.binding,
+					Taken: func(candidate PortBinding) bool {
+						for _, other := range used {
+							if (other.service != name || other.index != b.index) && other.binding.overlaps(candidate) {
+								return true
+							}
+						}
+						return false
+					},
+					Busy: func(candidate PortBinding) bool {
+						// Skip moved ports that something else on the host already listens on
+						if r.Probe == nil || r.Probe.Available(candidate) {
+							return false
+						}
+						logger.Infof("Port %s for service %s is in use on the host, skipping it", candidate, name)
+						avoided = append(avoided, AvoidedPort{Service: name, Binding: candidate})
+						return true
+					},
+				})
+				if err != nil {
+					return nil, fmt.Errorf("unable to resolve port conflict: %w", err)
 				}
 
-				// Update the port and mark it as used
-				logger.Infof("Adjusting port for service %s from %d to %d", name, hostPort, newPort)
-				port.Published = strconv.FormatUint(uint64(newPort), 10)
-				usedPorts[newPort] = true
+				// Update the port
+				if newBinding.Port == 0 {
+					logger.Infof("Dropping host port %s of service %s, Docker will pick a free one", b.binding, name)
+				} else {
+					logger.Infof("Adjusting port for service %s from %s to %s", name, b.binding, newBinding.published())
+					used = append(used, serviceBinding{service: name, index: b.index, binding: newBinding})
+				}
+				service.Ports[b.index].Published = newBinding.published()
 			}
+			claimed = append(claimed, own...)
+			services[name] = service
 		}
-		services[name] = service
 	}
 
 	// Check for any remaining conflicts after resolution
 	remainingConflicts := DetectPortConflicts(services, logger)
 	if len(remainingConflicts) > 0 {
-		return fmt.Errorf("unable to resolve all port conflicts: %v", remainingConflicts)
+		return nil, fmt.Errorf("unable to resolve all port conflicts: %v", remainingConflicts)
 	}
 
-	return nil
+	return avoided, nil
 }
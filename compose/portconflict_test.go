@@ -6,9 +6,23 @@ import (
 	"github.com/compose-spec/compose-go/v2/types"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
+// busyPorts is a port probe reporting the listed ports as in use
+type busyPorts map[uint32]bool
+
+// Available reports whether none of the binding's ports are listed
+func (b busyPorts) Available(binding PortBinding) bool {
+	for port := binding.Port; port <= binding.last(); port++ {
+		if b[port] {
+			return false
+		}
+	}
+	return true
+}
+
 // PortConflictTestSuite defines the test suite for port conflict functionality
 type PortConflictTestSuite struct {
 	suite.Suite
@@ -25,7 +39,7 @@ func (suite *PortConflictTestSuite) TestDetectPortConflicts() {
 	tests := []struct {
 		name     string
 		services types.Services
-		want     map[uint32][]string
+		want     []PortConflict
 	}{
 		{
 			name: "no conflicts",
@@ -41,7 +55,7 @@ func (suite *PortConflictTestSuite) TestDetectPortConflicts() {
 					},
 				},
 			},
-			want: map[uint32][]string{},
+			want: nil,
 		},
 		{
 			name: "simple conflict",
@@ -57,8 +71,8 @@ func (suite *PortConflictTestSuite) TestDetectPortConflicts() {
 					},
 				},
 			},
-			want: map[uint32][]string{
-				80: {"web1", "web2"},
+			want: []PortConflict{
+				{HostPort: 80, Protocol: "tcp", Services: []string{"web1", "web2"}},
 			},
 		},
 		{
@@ -77,9 +91,9 @@ func (suite *PortConflictTestSuite) TestDetectPortConflicts() {
 					},
 				},
 			},
-			want: map[uint32][]string{
-				80:  {"web1", "web2"},
-				443: {"web1", "web2"},
+			want: []PortConflict{
+				{HostPort: 80, Protocol: "tcp", Services: []string{"web1", "web2"}},
+				{HostPort: 443, Protocol: "tcp", Services: []string{"web1", "web2"}},
 			},
 		},
 		{
@@ -96,7 +110,264 @@ func (suite *PortConflictTestSuite) TestDetectPortConflicts() {
 					},
 				},
 			},
-			want: map[uint32][]string{},
+			want: nil,
+		},
+		{
+			name: "different protocols",
+			services: types.Services{
+				"dns1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "53", Target: 53, Protocol: "tcp"},
+					},
+				},
+				"dns2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "53", Target: 53, Protocol: "udp"},
+					},
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "same protocol",
+			services: types.Services{
+				"dns1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "53", Target: 53, Protocol: "udp"},
+					},
+				},
+				"dns2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "53", Target: 53, Protocol: "UDP"},
+					},
+				},
+			},
+			want: []PortConflict{
+				{HostPort: 53, Protocol: "udp", Services: []string{"dns1", "dns2"}},
+			},
+		},
+		{
+			name: "missing protocol defaults to tcp",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "80", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "80", Target: 80, Protocol: "tcp"},
+					},
+				},
+			},
+			want: []PortConflict{
+				{HostPort: 80, Protocol: "tcp", Services: []string{"web1", "web2"}},
+			},
+		},
+		{
+			name: "different host addresses",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "127.0.0.1", Published: "80", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "10.0.0.5", Published: "80", Target: 80},
+					},
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "same host address",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "127.0.0.1", Published: "80", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "127.0.0.1", Published: "80", Target: 80},
+					},
+				},
+			},
+			want: []PortConflict{
+				{HostIP: "127.0.0.1", HostPort: 80, Protocol: "tcp", Services: []string{"web1", "web2"}},
+			},
+		},
+		{
+			name: "every address overlaps a single address",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "0.0.0.0", Published: "80", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "127.0.0.1", Published: "80", Target: 80},
+					},
+				},
+			},
+			want: []PortConflict{
+				{HostPort: 80, Protocol: "tcp", Services: []string{"web1", "web2"}},
+			},
+		},
+		{
+			name: "empty address overlaps a single address",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "80", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "10.0.0.5", Published: "80", Target: 80},
+					},
+				},
+			},
+			want: []PortConflict{
+				{HostPort: 80, Protocol: "tcp", Services: []string{"web1", "web2"}},
+			},
+		},
+		{
+			name: "empty address overlaps every address",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "80", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "0.0.0.0", Published: "80", Target: 80},
+					},
+				},
+			},
+			want: []PortConflict{
+				{HostPort: 80, Protocol: "tcp", Services: []string{"web1", "web2"}},
+			},
+		},
+		{
+			name: "every address with a different protocol",
+			services: types.Services{
+				"dns1": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "0.0.0.0", Published: "53", Target: 53, Protocol: "tcp"},
+					},
+				},
+				"dns2": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "127.0.0.1", Published: "53", Target: 53, Protocol: "udp"},
+					},
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "single port inside a range",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8000-8010", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8005", Target: 80},
+					},
+				},
+			},
+			want: []PortConflict{
+				{HostPort: 8000, EndPort: 8010, Protocol: "tcp", Services: []string{"web1", "web2"}},
+			},
+		},
+		{
+			name: "overlapping ranges",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8000-8010", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8010-8020", Target: 80},
+					},
+				},
+			},
+			want: []PortConflict{
+				{HostPort: 8000, EndPort: 8020, Protocol: "tcp", Services: []string{"web1", "web2"}},
+			},
+		},
+		{
+			name: "adjacent ranges",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8000-8010", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8011-8020", Target: 80},
+					},
+				},
+				"web3": {
+					Ports: []types.ServicePortConfig{
+						{Published: "7999", Target: 80},
+					},
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "ranges on different protocols",
+			services: types.Services{
+				"rtp1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "10000-10100", Target: 10000, Protocol: "udp"},
+					},
+				},
+				"rtp2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "10050", Target: 10050, Protocol: "tcp"},
+					},
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "invalid range",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8010-8000", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8005", Target: 80},
+					},
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "one service on several addresses",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "127.0.0.1", Published: "80", Target: 80},
+						{HostIP: "0.0.0.0", Published: "80", Target: 8080},
+					},
+				},
+			},
+			want: nil,
 		},
 	}
 
@@ -108,6 +379,14 @@ func (suite *PortConflictTestSuite) TestDetectPortConflicts() {
 	}
 }
 
+// TestPortConflictString tests the description of conflicting bindings
+func (suite *PortConflictTestSuite) TestPortConflictString() {
+	assert.Equal(suite.T(), "80/tcp", PortConflict{HostPort: 80, Protocol: "tcp"}.String())
+	assert.Equal(suite.T(), "127.0.0.1:53/udp", PortConflict{HostIP: "127.0.0.1", HostPort: 53, Protocol: "udp"}.String())
+	assert.Equal(suite.T(), "[::1]:80/tcp", PortConflict{HostIP: "::1", HostPort: 80, Protocol: "tcp"}.String())
+	assert.Equal(suite.T(), "127.0.0.1:8000-8010/tcp", PortConflict{HostIP: "127.0.0.1", HostPort: 8000, EndPort: 8010, Protocol: "tcp"}.String())
+}
+
 // TestResolvePortConflicts tests the port conflict resolution functionality
 func (suite *PortConflictTestSuite) TestResolvePortConflicts() {
 	tests := []struct {
@@ -208,6 +487,163 @@ func (suite *PortConflictTestSuite) TestResolvePortConflicts() {
 			},
 			wantErr: false,
 		},
+		{
+			name: "different protocols are not shifted",
+			services: types.Services{
+				"dns1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "53", Target: 53, Protocol: "tcp"},
+					},
+				},
+				"dns2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "53", Target: 53, Protocol: "udp"},
+					},
+				},
+			},
+			offset: 100,
+			want: types.Services{
+				"dns1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "53", Target: 53, Protocol: "tcp"},
+					},
+				},
+				"dns2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "53", Target: 53, Protocol: "udp"},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "different host addresses are not shifted",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "127.0.0.1", Published: "80", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "10.0.0.5", Published: "80", Target: 80},
+					},
+				},
+			},
+			offset: 100,
+			want: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "127.0.0.1", Published: "80", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "10.0.0.5", Published: "80", Target: 80},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "every address conflict resolution",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "127.0.0.1", Published: "80", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "10.0.0.5", Published: "80", Target: 80},
+					},
+				},
+				"web3": {
+					Ports: []types.ServicePortConfig{
+						{Published: "80", Target: 80},
+					},
+				},
+			},
+			offset: 100,
+			want: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "127.0.0.1", Published: "80", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{HostIP: "10.0.0.5", Published: "80", Target: 80},
+					},
+				},
+				"web3": {
+					Ports: []types.ServicePortConfig{
+						{Published: "280", Target: 80},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "range shifted as a block",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8005", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8000-8010", Target: 80},
+					},
+				},
+			},
+			offset: 100,
+			want: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8005", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8100-8110", Target: 80},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "single port shifted out of a range",
+			services: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8000-8010", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8005", Target: 80},
+						{Published: "9000-9001", Target: 90},
+					},
+				},
+			},
+			offset: 100,
+			want: types.Services{
+				"web1": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8000-8010", Target: 80},
+					},
+				},
+				"web2": {
+					Ports: []types.ServicePortConfig{
+						{Published: "8105", Target: 80},
+						{Published: "9000-9001", Target: 90},
+					},
+				},
+			},
+			wantErr: false,
+		},
 		{
 			name: "unresolvable conflict",
 			services: types.Services{
@@ -247,6 +683,142 @@ func (suite *PortConflictTestSuite) TestResolvePortConflicts() {
 	}
 }
 
+// TestResolvePortConflictsWithRangeInUse tests that a shifted range may not land on ports in use
+func (suite *PortConflictTestSuite) TestResolvePortConflictsWithRangeInUse() {
+	services := types.Services{
+		"web1": {
+			Ports: []types.ServicePortConfig{
+				{Published: "8000-8010", Target: 80},
+			},
+		},
+		"web2": {
+			Ports: []types.ServicePortConfig{
+				{Published: "8000-8010", Target: 80},
+			},
+		},
+		"web3": {
+			Ports: []types.ServicePortConfig{
+				{Published: "8105", Target: 80},
+			},
+		},
+	}
+
+	err := ResolvePortConflicts(services, 100, suite.logger)
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "unable to resolve port conflict: port 8100-8110 is already in use after applying offset")
+}
+
+// TestPortResolverWithOrder tests that services earlier in the order keep their ports
+func (suite *PortConflictTestSuite) TestPortResolverWithOrder() {
+	services := types.Services{
+		"api_app": {
+			Ports: []types.ServicePortConfig{
+				{Published: "80", Target: 80},
+			},
+		},
+		"web_app": {
+			Ports: []types.ServicePortConfig{
+				{Published: "80", Target: 80},
+			},
+		},
+		"web_proxy": {
+			Ports: []types.ServicePortConfig{
+				{Published: "80", Target: 80},
+			},
+		},
+		"db_admin": {
+			Ports: []types.ServicePortConfig{
+				{Published: "80", Target: 80},
+			},
+		},
+	}
+
+	// Services missing from the order come last, alphabetically
+	resolver := PortResolver{Strategy: OffsetStrategy{Offset: 100}, Order: []string{"web_proxy", "web_app", "api_app"}}
+	_, err := resolver.Resolve(services, suite.logger)
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), "80", services["web_proxy"].Ports[0].Published)
+	assert.Equal(suite.T(), "180", services["web_app"].Ports[0].Published)
+	assert.Equal(suite.T(), "280", services["api_app"].Ports[0].Published)
+	assert.Equal(suite.T(), "380", services["db_admin"].Ports[0].Published)
+}
+
+// TestPortResolverWithProbe tests that moved ports in use on the host are skipped and reported
+func (suite *PortConflictTestSuite) TestPortResolverWithProbe() {
+	services := types.Services{
+		"web1": {
+			Ports: []types.ServicePortConfig{
+				{Published: "80", Target: 80},
+			},
+		},
+		"web2": {
+			Ports: []types.ServicePortConfig{
+				{Published: "80", Target: 80},
+				{Published: "8000-8010", Target: 8000},
+			},
+		},
+		"web3": {
+			Ports: []types.ServicePortConfig{
+				{Published: "8005", Target: 8000},
+			},
+		},
+	}
+
+	// Ports kept by the first service are never probed
+	probe := busyPorts{80: true, 180: true, 8108: true}
+	avoided, err := PortResolver{Strategy: OffsetStrategy{Offset: 100}, Probe: probe}.Resolve(services, suite.logger)
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), "80", services["web1"].Ports[0].Published)
+	assert.Equal(suite.T(), "280", services["web2"].Ports[0].Published)
+	assert.Equal(suite.T(), "8000-8010", services["web2"].Ports[1].Published)
+	assert.Equal(suite.T(), "8105", services["web3"].Ports[0].Published)
+	assert.Equal(suite.T(), []AvoidedPort{
+		{Service: "web2", Binding: PortBinding{Port: 180, Protocol: "tcp"}},
+	}, avoided)
+
+	// Ranges are probed as a whole
+	services = types.Services{
+		"web1": {
+			Ports: []types.ServicePortConfig{
+				{Published: "8005", Target: 8000},
+			},
+		},
+		"web2": {
+			Ports: []types.ServicePortConfig{
+				{Published: "8000-8010", Target: 8000},
+			},
+		},
+	}
+	avoided, err = PortResolver{Strategy: OffsetStrategy{Offset: 100}, Probe: probe}.Resolve(services, suite.logger)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "8200-8210", services["web2"].Ports[0].Published)
+	assert.Equal(suite.T(), []AvoidedPort{
+		{Service: "web2", Binding: PortBinding{Port: 8100, EndPort: 8110, Protocol: "tcp"}},
+	}, avoided)
+}
+
+// TestPortResolverWithProbeWithoutOffset tests that a busy port cannot be skipped without an offset
+func (suite *PortConflictTestSuite) TestPortResolverWithProbeWithoutOffset() {
+	services := types.Services{
+		"web1": {
+			Ports: []types.ServicePortConfig{
+				{Published: "80", Target: 80},
+			},
+		},
+		"web2": {
+			Ports: []types.ServicePortConfig{
+				{Published: "80", Target: 80},
+			},
+		},
+	}
+
+	_, err := PortResolver{Strategy: OffsetStrategy{}, Probe: busyPorts{80: true}}.Resolve(services, suite.logger)
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "unable to resolve port conflict: port 80 is in use on the host")
+}
+
 // Run the test suite
 func TestPortConflictTestSuite(t *testing.T) {
 	suite.Run(t, new(PortConflictTestSuite))
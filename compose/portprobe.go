@@ -0,0 +1,59 @@
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PortProbe checks whether host ports are free to publish
+type PortProbe interface {
+	// Available reports whether every port of the binding is free on the host
+	Available(binding PortBinding) bool
+}
+
+// HostPortProbe checks ports by briefly binding them on the local machine
+type HostPortProbe struct{}
+
+// Available tries to bind each port of the binding on its address and protocol. Only ports
+// something already listens on count as taken: when a port cannot be bound for another reason,
+// e.g. an address this machine does not have, the probe cannot tell and reports it available.
+func (HostPortProbe) Available(binding PortBinding) bool {
+	logger := logrus.New().WithField("function", "HostPortProbe.Available")
+
+	for port := binding.Port; port <= binding.last(); port++ {
+		address := net.JoinHostPort(binding.HostIP, strconv.FormatUint(uint64(port), 10))
+		var closer io.Closer
+		var err error
+		if binding.Protocol == "udp" {
+			closer, err = net.ListenPacket("udp", address)
+		} else {
+			closer, err = net.Listen("tcp", address)
+		}
+		if errors.Is(err, syscall.EADDRINUSE) {
+			return false
+		}
+		if err != nil {
+			logger.Warnf("Unable to probe port %s, assuming it is free: %v", binding, err)
+			return true
+		}
+		_ = closer.Close()
+	}
+	return true
+}
+
+// AvoidedPort records a host port skipped during port resolution because it was in use
+type AvoidedPort struct {
+	Service string      // Merged name of the service the port was meant for
+	Binding PortBinding // Ports found in use on the host
+}
+
+// String returns a human-readable description of the avoided port
+func (a AvoidedPort) String() string {
+	return fmt.Sprintf("%s %s", a.Service, a.Binding)
+}
@@ -0,0 +1,61 @@
+package compose
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// PortProbeTestSuite defines the test suite for probing host ports
+type PortProbeTestSuite struct {
+	suite.Suite
+}
+
+// TestHostPortProbeTCP tests that a port with a TCP listener is reported as in use
+func (suite *PortProbeTestSuite) TestHostPortProbeTCP() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err)
+	port := uint32(listener.Addr().(*net.TCPAddr).Port)
+
+	probe := HostPortProbe{}
+	assert.False(suite.T(), probe.Available(PortBinding{HostIP: "127.0.0.1", Port: port, Protocol: "tcp"}))
+	assert.False(suite.T(), probe.Available(PortBinding{HostIP: "127.0.0.1", Port: port - 1, EndPort: port + 1, Protocol: "tcp"}))
+
+	require.NoError(suite.T(), listener.Close())
+	assert.True(suite.T(), probe.Available(PortBinding{HostIP: "127.0.0.1", Port: port, Protocol: "tcp"}))
+}
+
+// TestHostPortProbeUDP tests that a port with a UDP listener is reported as in use for UDP only
+func (suite *PortProbeTestSuite) TestHostPortProbeUDP() {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(suite.T(), err)
+	port := uint32(conn.LocalAddr().(*net.UDPAddr).Port)
+
+	probe := HostPortProbe{}
+	assert.False(suite.T(), probe.Available(PortBinding{HostIP: "127.0.0.1", Port: port, Protocol: "udp"}))
+
+	require.NoError(suite.T(), conn.Close())
+	assert.True(suite.T(), probe.Available(PortBinding{HostIP: "127.0.0.1", Port: port, Protocol: "udp"}))
+}
+
+// TestHostPortProbeUnknownAddress tests that ports on addresses this machine lacks are not reported as in use
+func (suite *PortProbeTestSuite) TestHostPortProbeUnknownAddress() {
+	// 192.0.2.0/24 is reserved for documentation and never assigned to a local interface
+	probe := HostPortProbe{}
+	assert.True(suite.T(), probe.Available(PortBinding{HostIP: "192.0.2.10", Port: 8080, Protocol: "tcp"}))
+	assert.True(suite.T(), probe.Available(PortBinding{HostIP: "192.0.2.10", Port: 8080, Protocol: "udp"}))
+}
+
+// TestAvoidedPortString tests the description of avoided ports
+func (suite *PortProbeTestSuite) TestAvoidedPortString() {
+	avoided := AvoidedPort{Service: "web_app", Binding: PortBinding{Port: 180, Protocol: "tcp"}}
+	assert.Equal(suite.T(), "web_app 180/tcp", avoided.String())
+}
+
+// TestPortProbeTestSuite runs the test suite
+func TestPortProbeTestSuite(t *testing.T) {
+	suite.Run(t, new(PortProbeTestSuite))
+}
@@ -0,0 +1,181 @@
+package compose
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultPortOffset is the offset used by the offset strategy unless another is configured
+const DefaultPortOffset = 100
+
+// Port strategy names accepted by ParsePortStrategy
+const (
+	PortStrategyOffset    = "offset"
+	PortStrategyNextFree  = "next-free"
+	PortStrategyStackBase = "stack-base"
+	PortStrategyEphemeral = "ephemeral"
+)
+
+// maxPort is the highest host port
+const maxPort = 65535
+
+// PortRequest describes a binding that has to move because an earlier service holds its ports
+type PortRequest struct {
+	Service  string      // Merged name of the service
+	Stack    string      // Prefix of the stack the service belongs to
+	Position int         // Position of the service in the conflict, the first service keeps its ports
+	Binding  PortBinding // Original binding
+	// Taken reports whether a candidate overlaps ports of other services
+	Taken func(candidate PortBinding) bool
+	// Busy reports whether a candidate is in use on the host, recording it as avoided.
+	// It always reports false when no probe is configured.
+	Busy func(candidate PortBinding) bool
+}
+
+// PortStrategy picks the host ports a conflicting binding moves to. A binding with a zero
+// Port drops the host port, letting Docker pick one.
+type PortStrategy interface {
+	Assign(request PortRequest) (PortBinding, error)
+}
+
+// OffsetStrategy moves a binding by Offset times the position of its service in the conflict,
+// adding the offset again while the ports are in use on the host
+type OffsetStrategy struct {
+	Offset uint32
+}
+
+// Assign implements PortStrategy
+func (s OffsetStrategy) Assign(request PortRequest) (PortBinding, error) {
+	step := uint32(request.Position)
+	candidate := request.Binding.shift(s.Offset * step)
+
+	// Skip moved ports that something else on the host already listens on
+	for candidate.last() <= maxPort && request.Busy(candidate) {
+		if s.Offset == 0 {
+			return PortBinding{}, fmt.Errorf("port %s is in use on the host", candidate.published())
+		}
+		step++
+		candidate = request.Binding.shift(s.Offset * step)
+	}
+
+	if candidate.last() > maxPort {
+		return PortBinding{}, fmt.Errorf("port %s is out of range after applying offset", candidate.published())
+	}
+	if request.Taken(candidate) {
+		return PortBinding{}, fmt.Errorf("port %s is already in use after applying offset", candidate.published())
+	}
+	return candidate, nil
+}
+
+// NextFreeStrategy moves a binding to the first free ports between From and To. A zero From
+// searches from the port after the conflicting one, a zero To up to the highest port.
+type NextFreeStrategy struct {
+	From uint32
+	To   uint32
+}
+
+// Assign implements PortStrategy
+func (s NextFreeStrategy) Assign(request PortRequest) (PortBinding, error) {
+	from, to := s.From, s.To
+	if from == 0 {
+		from = request.Binding.Port + 1
+	}
+	if to == 0 {
+		to = maxPort
+	}
+
+	// Ranges move as a block, so the whole block has to fit
+	width := request.Binding.last() - request.Binding.Port
+	for port := from; port+width <= to; port++ {
+		candidate := request.Binding.shift(port - request.Binding.Port)
+		if request.Taken(candidate) || request.Busy(candidate) {
+			continue
+		}
+		return candidate, nil
+	}
+	return PortBinding{}, fmt.Errorf("no free port for %s between %d and %d", request.Binding, from, to)
+}
+
+// StackBaseStrategy moves a binding to the base port of its stack plus its original port,
+// e.g. port 80 of a stack based at 10000 moves to 10080
+type StackBaseStrategy struct {
+	Bases map[string]uint32 // Base port of each stack, by prefix
+}
+
+// Assign implements PortStrategy
+func (s StackBaseStrategy) Assign(request PortRequest) (PortBinding, error) {
+	base, ok := s.Bases[request.Stack]
+	if !ok {
+		return PortBinding{}, fmt.Errorf("no port base set for stack %s of service %s", request.Stack, request.Service)
+	}
+
+	candidate := request.Binding.shift(base)
+	if candidate.last() > maxPort {
+		return PortBinding{}, fmt.Errorf("port %s is out of range after applying the base of stack %s", candidate.published(), request.Stack)
+	}
+	if request.Taken(candidate) || request.Busy(candidate) {
+		return PortBinding{}, fmt.Errorf("port %s from the base of stack %s is already in use", candidate.published(), request.Stack)
+	}
+	return candidate, nil
+}
+
+// EphemeralStrategy drops the host port of a conflicting binding, letting Docker pick a free one
+type EphemeralStrategy struct{}
+
+// Assign implements PortStrategy
+func (EphemeralStrategy) Assign(request PortRequest) (PortBinding, error) {
+	dropped := request.Binding
+	dropped.Port, dropped.EndPort = 0, 0
+	return dropped, nil
+}
+
+// ParsePortStrategy returns the strategy with the given name. The range, given as FROM-TO,
+// only applies to the next-free strategy. Stack bases are filled in while merging.
+func ParsePortStrategy(name, portRange string) (PortStrategy, error) {
+	if portRange != "" && name != PortStrategyNextFree {
+		return nil, fmt.Errorf("port range %s only applies to the %s port strategy", portRange, PortStrategyNextFree)
+	}
+
+	switch name {
+	case "", PortStrategyOffset:
+		return OffsetStrategy{Offset: DefaultPortOffset}, nil
+	case PortStrategyNextFree:
+		if portRange == "" {
+			return NextFreeStrategy{}, nil
+		}
+		from, to, err := parsePortRange(portRange)
+		if err != nil {
+			return nil, err
+		}
+		return NextFreeStrategy{From: from, To: to}, nil
+	case PortStrategyStackBase:
+		return StackBaseStrategy{}, nil
+	case PortStrategyEphemeral:
+		return EphemeralStrategy{}, nil
+	default:
+		return nil, fmt.Errorf("invalid port strategy %q: expected %s, %s, %s or %s", name,
+			PortStrategyOffset, PortStrategyNextFree, PortStrategyStackBase, PortStrategyEphemeral)
+	}
+}
+
+// parsePortRange parses a FROM-TO range of host ports
+func parsePortRange(value string) (uint32, uint32, error) {
+	first, last, ok := strings.Cut(value, "-")
+	from, errFrom := strconv.ParseUint(first, 10, 16)
+	to, errTo := strconv.ParseUint(last, 10, 16)
+	if !ok || errFrom != nil || errTo != nil || from == 0 || from > to {
+		return 0, 0, fmt.Errorf("invalid port range %q: expected FROM-TO with ports between 1 and %d", value, maxPort)
+	}
+	return uint32(from), uint32(to), nil
+}
+
+// ParsePortBase parses a command-line stack port base given as STACK=PORT
+func ParsePortBase(value string) (string, uint32, error) {
+	stack, port, ok := strings.Cut(value, "=")
+	base, err := strconv.ParseUint(port, 10, 16)
+	if !ok || stack == "" || err != nil || base == 0 {
+		return "", 0, fmt.Errorf("invalid port base %q: expected STACK=PORT", value)
+	}
+	return stack, uint32(base), nil
+}
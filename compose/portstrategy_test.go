@@ -0,0 +1,214 @@
+package compose
+
+import (
+	"testing"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// PortStrategyTestSuite defines the test suite for port strategies
+type PortStrategyTestSuite struct {
+	suite.Suite
+	logger *logrus.Entry
+}
+
+// SetupTest runs before each test
+func (suite *PortStrategyTestSuite) SetupTest() {
+	suite.logger = logrus.New().WithField("test", true)
+}
+
+// request returns a port request whose taken and busy ports are the given ones
+func (suite *PortStrategyTestSuite) request(binding PortBinding, position int, taken, busy map[uint32]bool) PortRequest {
+	check := func(ports map[uint32]bool) func(PortBinding) bool {
+		return func(candidate PortBinding) bool {
+			for port := candidate.Port; port <= candidate.last(); port++ {
+				if ports[port] {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return PortRequest{
+		Service:  "api_app",
+		Stack:    "api",
+		Position: position,
+		Binding:  binding,
+		Taken:    check(taken),
+		Busy:     check(busy),
+	}
+}
+
+// TestParsePortStrategy tests selecting strategies by name
+func (suite *PortStrategyTestSuite) TestParsePortStrategy() {
+	tests := []struct {
+		name      string
+		portRange string
+		want      PortStrategy
+	}{
+		{name: "", want: OffsetStrategy{Offset: DefaultPortOffset}},
+		{name: "offset", want: OffsetStrategy{Offset: DefaultPortOffset}},
+		{name: "next-free", want: NextFreeStrategy{}},
+		{name: "next-free", portRange: "20000-29999", want: NextFreeStrategy{From: 20000, To: 29999}},
+		{name: "stack-base", want: StackBaseStrategy{}},
+		{name: "ephemeral", want: EphemeralStrategy{}},
+	}
+	for _, tt := range tests {
+		strategy, err := ParsePortStrategy(tt.name, tt.portRange)
+		require.NoError(suite.T(), err, tt.name)
+		assert.Equal(suite.T(), tt.want, strategy, tt.name)
+	}
+
+	_, err := ParsePortStrategy("random", "")
+	assert.ErrorContains(suite.T(), err, `invalid port strategy "random"`)
+	_, err = ParsePortStrategy("offset", "20000-29999")
+	assert.ErrorContains(suite.T(), err, "only applies to the next-free port strategy")
+	for _, portRange := range []string{"20000", "0-100", "300-200", "1-70000", "a-b"} {
+		_, err = ParsePortStrategy("next-free", portRange)
+		assert.ErrorContains(suite.T(), err, "invalid port range", portRange)
+	}
+}
+
+// TestParsePortBase tests parsing of command-line stack port bases
+func (suite *PortStrategyTestSuite) TestParsePortBase() {
+	stack, base, err := ParsePortBase("web=10000")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "web", stack)
+	assert.Equal(suite.T(), uint32(10000), base)
+
+	for _, value := range []string{"web", "=10000", "web=", "web=0", "web=70000"} {
+		_, _, err = ParsePortBase(value)
+		assert.Error(suite.T(), err, value)
+	}
+}
+
+// TestOffsetStrategy tests moving ports by the offset, skipping busy ports
+func (suite *PortStrategyTestSuite) TestOffsetStrategy() {
+	strategy := OffsetStrategy{Offset: 100}
+	binding := PortBinding{Port: 80, Protocol: "tcp"}
+
+	moved, err := strategy.Assign(suite.request(binding, 2, nil, nil))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), uint32(280), moved.Port)
+
+	moved, err = strategy.Assign(suite.request(binding, 1, nil, map[uint32]bool{180: true}))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), uint32(280), moved.Port)
+
+	_, err = strategy.Assign(suite.request(binding, 1, map[uint32]bool{180: true}, nil))
+	assert.ErrorContains(suite.T(), err, "port 180 is already in use after applying offset")
+
+	_, err = strategy.Assign(suite.request(PortBinding{Port: 65500, Protocol: "tcp"}, 1, nil, nil))
+	assert.ErrorContains(suite.T(), err, "port 65600 is out of range")
+}
+
+// TestNextFreeStrategy tests moving ports to the next free ones within a range
+func (suite *PortStrategyTestSuite) TestNextFreeStrategy() {
+	binding := PortBinding{Port: 80, Protocol: "tcp"}
+
+	// Without a range the search starts after the conflicting port
+	moved, err := NextFreeStrategy{}.Assign(suite.request(binding, 3, map[uint32]bool{81: true}, map[uint32]bool{82: true}))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), uint32(83), moved.Port)
+
+	moved, err = NextFreeStrategy{From: 20000, To: 20010}.Assign(suite.request(binding, 1, map[uint32]bool{20000: true}, nil))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), uint32(20001), moved.Port)
+
+	// Ranges need room for the whole block
+	rangeBinding := PortBinding{Port: 8000, EndPort: 8002, Protocol: "tcp"}
+	moved, err = NextFreeStrategy{From: 20000, To: 20010}.Assign(suite.request(rangeBinding, 1, map[uint32]bool{20002: true}, nil))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), PortBinding{Port: 20003, EndPort: 20005, Protocol: "tcp"}, moved)
+
+	_, err = NextFreeStrategy{From: 20000, To: 20001}.Assign(suite.request(rangeBinding, 1, nil, nil))
+	assert.ErrorContains(suite.T(), err, "no free port for 8000-8002/tcp between 20000 and 20001")
+}
+
+// TestStackBaseStrategy tests moving ports to the base of their stack
+func (suite *PortStrategyTestSuite) TestStackBaseStrategy() {
+	strategy := StackBaseStrategy{Bases: map[string]uint32{"api": 20000}}
+	binding := PortBinding{HostIP: "127.0.0.1", Port: 80, Protocol: "tcp"}
+
+	moved, err := strategy.Assign(suite.request(binding, 1, nil, nil))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), PortBinding{HostIP: "127.0.0.1", Port: 20080, Protocol: "tcp"}, moved)
+
+	_, err = strategy.Assign(suite.request(binding, 1, nil, map[uint32]bool{20080: true}))
+	assert.ErrorContains(suite.T(), err, "port 20080 from the base of stack api is already in use")
+
+	_, err = StackBaseStrategy{}.Assign(suite.request(binding, 1, nil, nil))
+	assert.ErrorContains(suite.T(), err, "no port base set for stack api of service api_app")
+}
+
+// TestEphemeralStrategy tests dropping the host port
+func (suite *PortStrategyTestSuite) TestEphemeralStrategy() {
+	binding := PortBinding{HostIP: "127.0.0.1", Port: 8000, EndPort: 8010, Protocol: "udp"}
+	moved, err := EphemeralStrategy{}.Assign(suite.request(binding, 1, nil, nil))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), PortBinding{HostIP: "127.0.0.1", Protocol: "udp"}, moved)
+}
+
+// TestResolveWithStrategies tests resolving conflicts with each strategy
+func (suite *PortStrategyTestSuite) TestResolveWithStrategies() {
+	newServices := func() types.Services {
+		return types.Services{
+			"web_app": {
+				Ports: []types.ServicePortConfig{
+					{Published: "80", Target: 80},
+				},
+			},
+			"api_app": {
+				Ports: []types.ServicePortConfig{
+					{Published: "80", Target: 80},
+				},
+			},
+			"db_admin": {
+				Ports: []types.ServicePortConfig{
+					{Published: "80", Target: 80},
+					{Published: "81", Target: 81},
+				},
+			},
+		}
+	}
+	order := []string{"web_app", "api_app", "db_admin"}
+	stacks := map[string]string{"web_app": "web", "api_app": "api", "db_admin": "db"}
+
+	tests := []struct {
+		name     string
+		strategy PortStrategy
+		want     []string // Published ports of web_app, api_app and db_admin
+	}{
+		{name: "offset", strategy: OffsetStrategy{Offset: 100}, want: []string{"80", "180", "280", "81"}},
+		{name: "next free", strategy: NextFreeStrategy{}, want: []string{"80", "82", "83", "81"}},
+		{name: "next free in range", strategy: NextFreeStrategy{From: 20000, To: 29999}, want: []string{"80", "20000", "20001", "81"}},
+		{name: "stack base", strategy: StackBaseStrategy{Bases: map[string]uint32{"api": 10000, "db": 20000}}, want: []string{"80", "10080", "20080", "81"}},
+		{name: "ephemeral", strategy: EphemeralStrategy{}, want: []string{"80", "", "", "81"}},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			services := newServices()
+			resolver := PortResolver{Strategy: tt.strategy, Order: order, Stacks: stacks}
+			_, err := resolver.Resolve(services, suite.logger)
+			require.NoError(suite.T(), err)
+
+			assert.Equal(suite.T(), tt.want, []string{
+				services["web_app"].Ports[0].Published,
+				services["api_app"].Ports[0].Published,
+				services["db_admin"].Ports[0].Published,
+				services["db_admin"].Ports[1].Published,
+			})
+			assert.Empty(suite.T(), DetectPortConflicts(services, suite.logger))
+		})
+	}
+}
+
+// TestPortStrategyTestSuite runs the test suite
+func TestPortStrategyTestSuite(t *testing.T) {
+	suite.Run(t, new(PortStrategyTestSuite))
+}
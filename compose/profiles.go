@@ -0,0 +1,99 @@
+package compose
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProfileSelector activates a compose profile in every stack, or in a single stack when Stack is set
+type ProfileSelector struct {
+	Stack string // Prefix of the stack the profile is activated in, empty for every stack
+	Name  string // Profile name as declared in the compose file
+}
+
+// ParseProfileSelector parses a command-line profile given as NAME or STACK:NAME
+func ParseProfileSelector(value string) (ProfileSelector, error) {
+	stack, name, scoped := strings.Cut(value, ":")
+	if !scoped {
+		stack, name = "", value
+	}
+	if name == "" || (scoped && stack == "") {
+		return ProfileSelector{}, fmt.Errorf("invalid profile %q: expected NAME or STACK:NAME", value)
+	}
+	return ProfileSelector{Stack: stack, Name: name}, nil
+}
+
+// String returns the selector in its command-line form
+func (s ProfileSelector) String() string {
+	if s.Stack == "" {
+		return s.Name
+	}
+	return s.Stack + ":" + s.Name
+}
+
+// applyProfiles disables the services of each file whose profiles are not active in its stack.
+// Files are loaded with every profile enabled, so that each stack can be filtered on its own.
+func applyProfiles(files []*ComposeFile, selectors []ProfileSelector) error {
+	// Every stack named by a selector must exist
+	for _, selector := range selectors {
+		if selector.Stack == "" {
+			continue
+		}
+		found := false
+		for _, cf := range files {
+			if cf.prefix() == selector.Stack {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("profile %s refers to unknown stack %s", selector, selector.Stack)
+		}
+	}
+
+	for _, cf := range files {
+		var active []string
+		for _, selector := range selectors {
+			if selector.Stack == "" || selector.Stack == cf.prefix() {
+				active = append(active, selector.Name)
+			}
+		}
+		project, err := cf.Project.WithProfiles(active)
+		if err != nil {
+			return fmt.Errorf("failed to apply profiles to %s: %w", cf.Path, err)
+		}
+		cf.Project = project
+	}
+
+	return nil
+}
+
+// prefixProfiles renames the profiles of the file's services to <prefix>_<profile>, so that
+// profiles with the same name in different stacks can be toggled independently
+func (cf *ComposeFile) prefixProfiles(prefix string) {
+	for name, service := range cf.Project.Services {
+		for i, profile := range service.Profiles {
+			service.Profiles[i] = prefix + "_" + profile
+		}
+		cf.Project.Services[name] = service
+	}
+}
+
+// activeProfiles returns the profiles declared by the enabled services of the files, which the
+// merged file must be run with for those services to start
+func activeProfiles(files []*ComposeFile) []string {
+	seen := make(map[string]bool)
+	var profiles []string
+	for _, cf := range files {
+		for _, service := range cf.Project.Services {
+			for _, profile := range service.Profiles {
+				if !seen[profile] {
+					seen[profile] = true
+					profiles = append(profiles, profile)
+				}
+			}
+		}
+	}
+	sort.Strings(profiles)
+	return profiles
+}
@@ -0,0 +1,136 @@
+package compose
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// ProfilesTestSuite defines the test suite for profile selection
+type ProfilesTestSuite struct {
+	suite.Suite
+	tmpDir string
+}
+
+// SetupTest runs before each test
+func (suite *ProfilesTestSuite) SetupTest() {
+	suite.tmpDir = suite.T().TempDir()
+}
+
+// loadStacks writes stacks that all declare a debug profile in the given directories,
+// web and db by default, and loads them
+func (suite *ProfilesTestSuite) loadStacks(dirs ...string) []*ComposeFile {
+	if len(dirs) == 0 {
+		dirs = []string{"web", "db"}
+	}
+	var files []*ComposeFile
+	for _, dir := range dirs {
+		file := writeFile(suite.T(), suite.tmpDir, filepath.Join(dir, "docker-compose.yml"), `
+services:
+  app:
+    image: nginx
+  debug:
+    image: busybox
+    profiles: [debug]
+  metrics:
+    image: prom/prometheus
+    profiles: [metrics, debug]
+`)
+
+		cf, err := NewComposeFile(file)
+		require.NoError(suite.T(), err)
+		files = append(files, cf)
+	}
+	return files
+}
+
+// TestParseProfileSelector tests parsing of command-line profiles
+func (suite *ProfilesTestSuite) TestParseProfileSelector() {
+	selector, err := ParseProfileSelector("debug")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), ProfileSelector{Name: "debug"}, selector)
+
+	selector, err = ParseProfileSelector("web:debug")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), ProfileSelector{Stack: "web", Name: "debug"}, selector)
+	assert.Equal(suite.T(), "web:debug", selector.String())
+
+	for _, value := range []string{"", "web:", ":debug"} {
+		_, err = ParseProfileSelector(value)
+		assert.Error(suite.T(), err, value)
+	}
+}
+
+// TestMergeWithoutProfiles tests that profile-gated services are left out by default
+func (suite *ProfilesTestSuite) TestMergeWithoutProfiles() {
+	files := suite.loadStacks()
+
+	// Verify that loading keeps every service
+	assert.Len(suite.T(), files[0].Project.Services, 3)
+
+	merged, _, err := MergeComposeFiles(files)
+	require.NoError(suite.T(), err)
+	assert.ElementsMatch(suite.T(), []string{"web_app", "db_app"}, merged.ServiceNames())
+	assert.Empty(suite.T(), merged.Profiles)
+}
+
+// TestMergeWithGlobalProfile tests that a profile without a stack is activated in every stack
+func (suite *ProfilesTestSuite) TestMergeWithGlobalProfile() {
+	merged, _, err := MergeComposeFiles(suite.loadStacks(), WithProfiles(ProfileSelector{Name: "metrics"}))
+	require.NoError(suite.T(), err)
+	assert.ElementsMatch(suite.T(), []string{"web_app", "web_metrics", "db_app", "db_metrics"}, merged.ServiceNames())
+	assert.Equal(suite.T(), []string{"debug", "metrics"}, merged.Profiles)
+}
+
+// TestMergeWithStackProfile tests that a stack profile is only activated in that stack
+func (suite *ProfilesTestSuite) TestMergeWithStackProfile() {
+	merged, _, err := MergeComposeFiles(suite.loadStacks(), WithProfiles(ProfileSelector{Stack: "web", Name: "debug"}))
+	require.NoError(suite.T(), err)
+	assert.ElementsMatch(suite.T(), []string{"web_app", "web_debug", "web_metrics", "db_app"}, merged.ServiceNames())
+}
+
+// TestMergeWithPrefixedProfiles tests that profile names are prefixed with their stack's prefix
+func (suite *ProfilesTestSuite) TestMergeWithPrefixedProfiles() {
+	merged, _, err := MergeComposeFiles(suite.loadStacks(),
+		WithProfiles(ProfileSelector{Stack: "db", Name: "debug"}),
+		WithPrefixedProfiles(),
+	)
+	require.NoError(suite.T(), err)
+	assert.ElementsMatch(suite.T(), []string{"web_app", "db_app", "db_debug", "db_metrics"}, merged.ServiceNames())
+	assert.Equal(suite.T(), []string{"db_debug"}, merged.Services["db_debug"].Profiles)
+	assert.Equal(suite.T(), []string{"db_metrics", "db_debug"}, merged.Services["db_metrics"].Profiles)
+	assert.Equal(suite.T(), []string{"db_debug", "db_metrics"}, merged.Profiles)
+}
+
+// TestMergeWithDisambiguatedStackProfile tests that stack profiles name the prefixes given by parent-dir
+func (suite *ProfilesTestSuite) TestMergeWithDisambiguatedStackProfile() {
+	stacks := func() []*ComposeFile {
+		return suite.loadStacks(filepath.Join("services", "api"), filepath.Join("legacy", "api"))
+	}
+
+	merged, _, err := MergeComposeFiles(stacks(), WithCollisionStrategy(CollisionParentDir),
+		WithProfiles(ProfileSelector{Stack: "services_api", Name: "debug"}))
+	require.NoError(suite.T(), err)
+	assert.Contains(suite.T(), merged.Services, "services_api_debug")
+	assert.NotContains(suite.T(), merged.Services, "legacy_api_debug")
+
+	// The directory name alone no longer names either stack
+	_, _, err = MergeComposeFiles(stacks(), WithCollisionStrategy(CollisionParentDir),
+		WithProfiles(ProfileSelector{Stack: "api", Name: "debug"}))
+	assert.ErrorContains(suite.T(), err, "profile api:debug refers to unknown stack api")
+}
+
+// TestMergeWithUnknownStackProfile tests that profiles of unknown stacks are rejected
+func (suite *ProfilesTestSuite) TestMergeWithUnknownStackProfile() {
+	_, _, err := MergeComposeFiles(suite.loadStacks(), WithProfiles(ProfileSelector{Stack: "cache", Name: "debug"}))
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "profile cache:debug refers to unknown stack cache")
+}
+
+// Run the test suite
+func TestProfilesTestSuite(t *testing.T) {
+	suite.Run(t, new(ProfilesTestSuite))
+}
@@ -0,0 +1,54 @@
+package compose
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// composeSource is a compose file parsed once for what the loaded project does not keep:
+// the variables it interpolates, the order of its services and its include directive
+type composeSource struct {
+	path     string
+	model    map[string]any // Raw model, before interpolation
+	services []string       // Service names in declaration order
+}
+
+// readSources parses the compose files, each of them once
+func readSources(paths []string) ([]composeSource, error) {
+	var sources []composeSource
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		source := composeSource{path: path}
+		if len(doc.Content) == 0 {
+			sources = append(sources, source)
+			continue
+		}
+		if err := doc.Decode(&source.model); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+
+		// Mapping nodes hold keys and values in turn, in the order the file declares them
+		root := doc.Content[0]
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value != "services" || root.Content[i+1].Kind != yaml.MappingNode {
+				continue
+			}
+			services := root.Content[i+1]
+			for j := 0; j < len(services.Content); j += 2 {
+				source.services = append(source.services, services.Content[j].Value)
+			}
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
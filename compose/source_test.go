@@ -0,0 +1,63 @@
+package compose
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// SourceTestSuite defines the test suite for parsing compose sources
+type SourceTestSuite struct {
+	suite.Suite
+	tmpDir string
+}
+
+// SetupTest runs before each test
+func (suite *SourceTestSuite) SetupTest() {
+	suite.tmpDir = suite.T().TempDir()
+}
+
+// TestReadSources tests that each file is parsed into its raw model and service order
+func (suite *SourceTestSuite) TestReadSources() {
+	file := writeFile(suite.T(), suite.tmpDir, "docker-compose.yml", `
+include:
+  - ${DB_DIR:-../db}/compose.yml
+services:
+  web:
+    image: nginx:${TAG}
+  api:
+    image: node
+`)
+	empty := writeFile(suite.T(), suite.tmpDir, "empty.yml", "")
+
+	sources, err := readSources([]string{file, empty})
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), sources, 2)
+
+	// Values are kept as written, before interpolation
+	assert.Equal(suite.T(), file, sources[0].path)
+	assert.Equal(suite.T(), []any{"${DB_DIR:-../db}/compose.yml"}, sources[0].model["include"])
+	assert.Equal(suite.T(), []string{"web", "api"}, sources[0].services)
+	assert.Equal(suite.T(), map[string]bool{"DB_DIR": true, "TAG": true}, referencedVariables(sources))
+
+	assert.Nil(suite.T(), sources[1].model)
+	assert.Empty(suite.T(), sources[1].services)
+}
+
+// TestReadSourcesErrors tests that unreadable and invalid files are reported
+func (suite *SourceTestSuite) TestReadSourcesErrors() {
+	_, err := readSources([]string{filepath.Join(suite.tmpDir, "missing.yml")})
+	assert.ErrorContains(suite.T(), err, "failed to read")
+
+	file := writeFile(suite.T(), suite.tmpDir, "invalid.yml", "services: [\n")
+	_, err = readSources([]string{file})
+	assert.ErrorContains(suite.T(), err, "failed to parse")
+}
+
+// TestSourceTestSuite runs the test suite
+func TestSourceTestSuite(t *testing.T) {
+	suite.Run(t, new(SourceTestSuite))
+}
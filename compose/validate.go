@@ -0,0 +1,141 @@
+package compose
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// ValidationProblem describes a reference of a merged service that does not resolve
+type ValidationProblem struct {
+	Service string // Merged name of the service holding the reference
+	Source  string // Compose file the service was declared in, empty when unknown
+	Field   string // Field holding the reference, e.g. "depends_on"
+	Message string // Description of the problem
+}
+
+// String returns the problem with the service and file it was found in
+func (p ValidationProblem) String() string {
+	service := "service " + p.Service
+	if p.Source != "" {
+		service += " (" + p.Source + ")"
+	}
+	return fmt.Sprintf("%s: %s %s", service, p.Field, p.Message)
+}
+
+// ValidationError is returned by Validate when references of the project do not resolve
+type ValidationError struct {
+	Problems []ValidationProblem
+}
+
+// Error lists every problem found in the project
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid merged project:")
+	for _, p := range e.Problems {
+		fmt.Fprintf(&b, "\n  %s", p)
+	}
+	return b.String()
+}
+
+// Validate checks that every depends_on, link, volume, network, config and secret reference of
+// the project's services resolves, and returns a ValidationError listing each one that does not.
+// Sources maps merged service names to the files they were declared in, as recorded in MergeReport.
+func Validate(project *types.Project, sources map[string]string) error {
+	var problems []ValidationProblem
+
+	for name, service := range project.Services {
+		source := sources[name]
+		report := func(field, format string, args ...any) {
+			problems = append(problems, ValidationProblem{
+				Service: name,
+				Source:  source,
+				Field:   field,
+				Message: fmt.Sprintf(format, args...),
+			})
+		}
+		serviceExists := func(ref string) bool {
+			_, ok := project.Services[ref]
+			return ok
+		}
+
+		for dependency, config := range service.DependsOn {
+			if !serviceExists(dependency) && config.Required {
+				report("depends_on", "refers to undefined service %s", dependency)
+			}
+		}
+
+		for _, link := range service.Links {
+			target, _, _ := strings.Cut(link, ":")
+			if !serviceExists(target) {
+				report("links", "refers to undefined service %s", target)
+			}
+		}
+
+		for field, ref := range map[string]string{
+			"network_mode": service.NetworkMode,
+			"ipc":          service.Ipc,
+			"pid":          service.Pid,
+			"uts":          service.Uts,
+			"cgroup":       service.Cgroup,
+		} {
+			if target, ok := strings.CutPrefix(ref, types.ServicePrefix); ok && !serviceExists(target) {
+				report(field, "refers to undefined service %s", target)
+			}
+		}
+
+		for _, from := range service.VolumesFrom {
+			if strings.HasPrefix(from, types.ContainerPrefix) {
+				continue
+			}
+			target, _, _ := strings.Cut(from, ":")
+			if !serviceExists(target) {
+				report("volumes_from", "refers to undefined service %s", target)
+			}
+		}
+
+		for _, volume := range service.Volumes {
+			if volume.Type != types.VolumeTypeVolume || volume.Source == "" {
+				continue
+			}
+			if _, ok := project.Volumes[volume.Source]; !ok {
+				report("volumes", "refers to undefined volume %s", volume.Source)
+			}
+		}
+
+		for network := range service.Networks {
+			if _, ok := project.Networks[network]; !ok {
+				report("networks", "refers to undefined network %s", network)
+			}
+		}
+
+		for _, config := range service.Configs {
+			if _, ok := project.Configs[config.Source]; !ok {
+				report("configs", "refers to undefined config %s", config.Source)
+			}
+		}
+
+		for _, secret := range service.Secrets {
+			if _, ok := project.Secrets[secret.Source]; !ok {
+				report("secrets", "refers to undefined secret %s", secret.Source)
+			}
+		}
+
+		if service.Build != nil {
+			for _, secret := range service.Build.Secrets {
+				if _, ok := project.Secrets[secret.Source]; !ok {
+					report("build.secrets", "refers to undefined secret %s", secret.Source)
+				}
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sortReport(problems, func(p ValidationProblem) []string { return []string{p.Service, p.Field, p.Message} })
+
+	return &ValidationError{Problems: problems}
+}
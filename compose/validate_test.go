@@ -0,0 +1,162 @@
+package compose
+
+import (
+	"testing"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// ValidateTestSuite defines the test suite for merged project validation
+type ValidateTestSuite struct {
+	suite.Suite
+	tmpDir string
+}
+
+// SetupTest runs before each test
+func (suite *ValidateTestSuite) SetupTest() {
+	suite.tmpDir = suite.T().TempDir()
+}
+
+// TestValidateMergedProject tests that a merged project with resolving references is valid
+func (suite *ValidateTestSuite) TestValidateMergedProject() {
+	web := writeFile(suite.T(), suite.tmpDir, "web/docker-compose.yml", `
+services:
+  app:
+    image: nginx
+    depends_on: [cache]
+    links: ["cache:redis"]
+    volumes_from: [cache]
+    volumes:
+      - data:/data
+    networks: [front]
+    configs: [nginx]
+    secrets: [token]
+  cache:
+    image: redis
+    networks: [front]
+volumes:
+  data:
+networks:
+  front:
+configs:
+  nginx:
+    file: ./nginx.conf
+secrets:
+  token:
+    file: ./token.txt
+`)
+	db := writeFile(suite.T(), suite.tmpDir, "db/docker-compose.yml", `
+services:
+  postgres:
+    image: postgres
+  backup:
+    image: busybox
+    network_mode: service:postgres
+`)
+
+	var files []*ComposeFile
+	for _, path := range []string{web, db} {
+		cf, err := NewComposeFile(path)
+		require.NoError(suite.T(), err)
+		files = append(files, cf)
+	}
+
+	merged, report, err := MergeComposeFiles(files)
+	require.NoError(suite.T(), err)
+	assert.NoError(suite.T(), Validate(merged, report.Sources))
+
+	// Verify that the report records the file every merged service was declared in
+	assert.Equal(suite.T(), web, report.Sources["web_app"])
+	assert.Equal(suite.T(), db, report.Sources["db_backup"])
+
+	// Verify that the sources are kept out of the merged file
+	for _, service := range merged.Services {
+		assert.NotContains(suite.T(), service.Extensions, "x-qec-source")
+	}
+}
+
+// TestValidateDanglingReferences tests that every dangling reference is reported with its source
+func (suite *ValidateTestSuite) TestValidateDanglingReferences() {
+	project := &types.Project{
+		Services: types.Services{
+			"web_app": {
+				Name: "web_app",
+				DependsOn: types.DependsOnConfig{
+					"db_postgres": {Condition: types.ServiceConditionStarted, Required: true},
+					"db_optional": {Condition: types.ServiceConditionStarted, Required: false},
+				},
+				Links:       []string{"web_cache:redis"},
+				VolumesFrom: []string{"web_data:ro", "container:external"},
+				Volumes: []types.ServiceVolumeConfig{
+					{Type: types.VolumeTypeVolume, Source: "web_data", Target: "/data"},
+					{Type: types.VolumeTypeVolume, Target: "/anonymous"},
+					{Type: types.VolumeTypeBind, Source: "/host", Target: "/host"},
+				},
+				Networks: map[string]*types.ServiceNetworkConfig{"web_front": nil},
+				Configs:  []types.ServiceConfigObjConfig{{Source: "web_nginx"}},
+				Secrets:  []types.ServiceSecretConfig{{Source: "web_token"}},
+				Build: &types.BuildConfig{
+					Context: ".",
+					Secrets: []types.ServiceSecretConfig{{Source: "web_npmrc"}},
+				},
+			},
+			"db_backup": {
+				Name:        "db_backup",
+				NetworkMode: "service:db_postgres",
+				Pid:         "host",
+			},
+		},
+	}
+
+	err := Validate(project, map[string]string{"web_app": "/stacks/web/docker-compose.yml"})
+	require.Error(suite.T(), err)
+
+	var validationErr *ValidationError
+	require.ErrorAs(suite.T(), err, &validationErr)
+	assert.Equal(suite.T(), []ValidationProblem{
+		{Service: "db_backup", Field: "network_mode", Message: "refers to undefined service db_postgres"},
+		{Service: "web_app", Source: "/stacks/web/docker-compose.yml", Field: "build.secrets", Message: "refers to undefined secret web_npmrc"},
+		{Service: "web_app", Source: "/stacks/web/docker-compose.yml", Field: "configs", Message: "refers to undefined config web_nginx"},
+		{Service: "web_app", Source: "/stacks/web/docker-compose.yml", Field: "depends_on", Message: "refers to undefined service db_postgres"},
+		{Service: "web_app", Source: "/stacks/web/docker-compose.yml", Field: "links", Message: "refers to undefined service web_cache"},
+		{Service: "web_app", Source: "/stacks/web/docker-compose.yml", Field: "networks", Message: "refers to undefined network web_front"},
+		{Service: "web_app", Source: "/stacks/web/docker-compose.yml", Field: "secrets", Message: "refers to undefined secret web_token"},
+		{Service: "web_app", Source: "/stacks/web/docker-compose.yml", Field: "volumes", Message: "refers to undefined volume web_data"},
+		{Service: "web_app", Source: "/stacks/web/docker-compose.yml", Field: "volumes_from", Message: "refers to undefined service web_data"},
+	}, validationErr.Problems)
+
+	// Verify that the message lists each problem with its service and file
+	assert.Contains(suite.T(), err.Error(), "service web_app (/stacks/web/docker-compose.yml): links refers to undefined service web_cache")
+	assert.Contains(suite.T(), err.Error(), "service db_backup: network_mode refers to undefined service db_postgres")
+}
+
+// TestValidateProfileDisabledService tests that references to a service left out by its profile are reported
+func (suite *ValidateTestSuite) TestValidateProfileDisabledService() {
+	web := writeFile(suite.T(), suite.tmpDir, "web/docker-compose.yml", `
+services:
+  app:
+    image: nginx
+    links: [debug]
+  debug:
+    image: busybox
+    profiles: [debug]
+`)
+
+	cf, err := NewComposeFile(web)
+	require.NoError(suite.T(), err)
+
+	merged, report, err := MergeComposeFiles([]*ComposeFile{cf})
+	require.NoError(suite.T(), err)
+
+	err = Validate(merged, report.Sources)
+	require.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "service web_app ("+web+"): links refers to undefined service")
+}
+
+// TestValidateTestSuite runs the test suite
+func TestValidateTestSuite(t *testing.T) {
+	suite.Run(t, new(ValidateTestSuite))
+}
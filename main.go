@@ -20,11 +20,46 @@ Usage:
   qec [OPTIONS] COMMAND [ARGS...]
 
 Options:
-  -f, --file FILE        Path to a docker-compose YAML file (can be specified multiple times)
+  -f, --file FILE[:PREFIX]
+                        Path to a docker-compose YAML file, optionally with the prefix for its
+                        resources (can be specified multiple times)
+  --prefix FILE=PREFIX  Prefix for the resources of a compose file given with -f
+                        (defaults to x-qec.prefix in the file, then the file's directory name)
+  --on-collision STRATEGY
+                        How to handle resource names claimed by several files: "fail" (default)
+                        or "parent-dir" to extend directory prefixes with parent directories
+  --override FILE       Deep-merge a compose file into the stack given just before it
+                        (files in the same directory as an earlier -f file are merged the same way)
   -d, --detach          Run containers in the background
   --dry-run             Simulate configuration without making runtime changes
   --verbose             Enable verbose logging
   --command COMMAND     Command to execute (default: "up")
+  --no-rewrite-hosts    Do not rewrite service hostnames in environment, command and healthcheck
+  --relative-paths      Write paths in docker-compose.merged.yml relative to its location
+  --port-strategy STRATEGY
+                        How to move conflicting ports: "offset" (default) adds 100 per service,
+                        "next-free" takes the next free port, "stack-base" adds the stack's base
+                        port and "ephemeral" lets Docker pick one (defaults to x-qec.ports.strategy)
+  --port-range FROM-TO  Ports searched by the next-free strategy
+  --port-base STACK=PORT
+                        Base port of a stack for the stack-base strategy (can be specified
+                        multiple times, defaults to x-qec.ports.base)
+  --probe-ports         Skip ports already in use on this machine when moving conflicting ports
+  --no-validate         Create containers without checking the merged project's references first
+  -p, --project-name NAME
+                        Name of the merged project, passed to docker compose
+                        (defaults to COMPOSE_PROJECT_NAME, then the first file's project name)
+  --env-file [FILE=]ENV_FILE
+                        Env file used to interpolate every stack, or only the stack of the
+                        compose file FILE given with -f (can be specified multiple times)
+  --profile [STACK:]NAME
+                        Activate a profile in every stack, or only in the stack with the given
+                        prefix (can be specified multiple times, defaults to COMPOSE_PROFILES)
+  --prefix-profiles     Rename profiles to STACK_NAME in the merged file so that profiles of
+                        different stacks can be toggled independently
+  --shared-network NAME[=STACK/SERVICE,...]
+                        Keep a network unprefixed and shared across files, optionally
+                        attaching services to it (can be specified multiple times)
 
 Commands:
   up                    Create and start containers
@@ -35,6 +70,17 @@ Commands:
   pull                  Pull service images
   push                  Push service images
   config               Validate and view the merged configuration
+  env                   Print the interpolation variables of each stack with their source
+  validate              Check that every reference of the merged project resolves, exiting
+                        non-zero with each dangling reference otherwise
+
+Environment:
+  Variables used to interpolate a stack's compose files are resolved in this order,
+  later sources overriding earlier ones:
+    1. the .env file in the stack's directory
+    2. env files given with --env-file ENV_FILE, in command-line order
+    3. env files given for the stack with --env-file FILE=ENV_FILE, in command-line order
+    4. the process environment
 
 Examples:
   # Run services from multiple compose files:
@@ -43,6 +89,21 @@ Examples:
   # View the merged configuration:
   qec -f folder1/docker-compose.yml -f folder2/docker-compose.yml --command config
 
+  # Two stacks living in directories with the same name:
+  qec -f services/api/docker-compose.yml:api -f legacy/api/docker-compose.yml:legacy_api up
+
+  # Combine a stack with its override file, then add another stack:
+  qec -f web/docker-compose.yml -f web/docker-compose.override.yml -f db/docker-compose.yml up
+
+  # Let the web stack reach the db stack's postgres over a shared network:
+  qec -f web/docker-compose.yml -f db/docker-compose.yml --shared-network backend=web/api,db/postgres up
+
+  # Enable the debug profile of the web stack only:
+  qec -f web/docker-compose.yml -f db/docker-compose.yml --profile web:debug up
+
+  # Check the merged stacks in CI:
+  qec -f web/docker-compose.yml -f db/docker-compose.yml validate
+
   # Dry run to see what would happen:
   qec -f folder1/docker-compose.yml -f folder2/docker-compose.yml --dry-run up
 
@@ -50,13 +111,27 @@ For more information, visit: https://github.com/yarlson/qec
 `
 
 var (
-	composeFiles multiFlag
-	verbose      bool
-	dryRun       bool
-	detach       bool
-	command      string
-	showHelp     bool
-	args         []string
+	composeFiles   []compose.FileSpec
+	prefixes       multiFlag
+	onCollision    string
+	sharedNetworks multiFlag
+	verbose        bool
+	noRewriteHosts bool
+	relativePaths  bool
+	noValidate     bool
+	probePorts     bool
+	portStrategy   string
+	portRange      string
+	portBases      multiFlag
+	profiles       multiFlag
+	envFiles       multiFlag
+	projectName    string
+	prefixProfiles bool
+	dryRun         bool
+	detach         bool
+	command        string
+	showHelp       bool
+	args           []string
 )
 
 // multiFlag is a custom flag type to handle multiple -f options
@@ -71,6 +146,103 @@ func (m *multiFlag) Set(value string) error {
 	return nil
 }
 
+// fileFlag is a custom flag type collecting compose files in command-line order,
+// so that -f and --override files keep their relative positions
+type fileFlag struct {
+	specs    *[]compose.FileSpec
+	override bool
+}
+
+func (f fileFlag) String() string {
+	if f.specs == nil {
+		return ""
+	}
+	var paths []string
+	for _, spec := range *f.specs {
+		if spec.Override == f.override {
+			paths = append(paths, spec.Path)
+		}
+	}
+	return strings.Join(paths, ", ")
+}
+
+func (f fileFlag) Set(value string) error {
+	spec := compose.FileSpec{Path: value, Override: f.override}
+	if !f.override {
+		spec = compose.ParseFileSpec(value)
+	}
+	*f.specs = append(*f.specs, spec)
+	return nil
+}
+
+// applyPrefixes sets the prefixes given as FILE=PREFIX on the matching compose files
+func applyPrefixes(specs []compose.FileSpec, mappings []string) error {
+	for _, mapping := range mappings {
+		file, prefix, ok := strings.Cut(mapping, "=")
+		if !ok || file == "" || prefix == "" {
+			return fmt.Errorf("invalid prefix %q: expected FILE=PREFIX", mapping)
+		}
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path for %s: %v", file, err)
+		}
+
+		found := false
+		for i, spec := range specs {
+			absPath, err := filepath.Abs(spec.Path)
+			if err != nil {
+				return fmt.Errorf("failed to get absolute path for %s: %v", spec.Path, err)
+			}
+			if absPath == absFile && !spec.Override {
+				specs[i].Prefix = prefix
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("invalid prefix %q: %s is not given with -f", mapping, file)
+		}
+	}
+	return nil
+}
+
+// applyEnvFiles assigns env files given as FILE=ENV_FILE to the stack holding the compose
+// file and returns the env files given without a compose file, which apply to every stack
+func applyEnvFiles(stacks []compose.StackFiles, values []string) ([]string, error) {
+	var global []string
+	for _, value := range values {
+		file, envFile, scoped := strings.Cut(value, "=")
+		if !scoped {
+			global = append(global, value)
+			continue
+		}
+		if file == "" || envFile == "" {
+			return nil, fmt.Errorf("invalid env file %q: expected ENV_FILE or FILE=ENV_FILE", value)
+		}
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for %s: %v", file, err)
+		}
+
+		found := false
+		for i, stack := range stacks {
+			for _, path := range append([]string{stack.Path}, stack.Overrides...) {
+				absPath, err := filepath.Abs(path)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get absolute path for %s: %v", path, err)
+				}
+				if absPath == absFile && !found {
+					stacks[i].EnvFiles = append(stacks[i].EnvFiles, envFile)
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid env file %q: %s is not given with -f", value, file)
+		}
+	}
+	return global, nil
+}
+
 // run executes the main program logic and returns an error if any
 func run() error {
 	if showHelp {
@@ -93,25 +265,132 @@ func run() error {
 		baseLogger.Info("Running in dry-run mode - no changes will be made")
 	}
 
-	// Load and process each compose file
+	// Apply prefixes given separately from the files
+	if err := applyPrefixes(composeFiles, prefixes); err != nil {
+		return err
+	}
+
+	// Group override files with the stacks they extend
+	stacks, err := compose.GroupFileSpecs(composeFiles)
+	if err != nil {
+		return fmt.Errorf("error grouping compose files: %v", err)
+	}
+
+	// Assign env files to the stacks they were given for
+	globalEnvFiles, err := applyEnvFiles(stacks, envFiles)
+	if err != nil {
+		return err
+	}
+
+	// Load and process each stack
 	var files []*compose.ComposeFile
-	for _, file := range composeFiles {
-		cf, err := compose.NewComposeFile(file)
+	for _, stack := range stacks {
+		cf, err := compose.LoadStack(stack, globalEnvFiles)
 		if err != nil {
-			return fmt.Errorf("error loading compose file %s: %v", file, err)
+			return fmt.Errorf("error loading compose file %s: %v", stack.Path, err)
 		}
 		files = append(files, cf)
 	}
 
+	// Print the interpolation variables instead of running docker compose
+	if command == "env" || (len(args) > 0 && args[0] == "env") {
+		return compose.WriteEnvironment(os.Stdout, files)
+	}
+
+	// Build merge options from the command line
+	var mergeOpts []compose.MergeOption
+	strategy, err := compose.ParseCollisionStrategy(onCollision)
+	if err != nil {
+		return err
+	}
+	mergeOpts = append(mergeOpts, compose.WithCollisionStrategy(strategy))
+	if noRewriteHosts {
+		mergeOpts = append(mergeOpts, compose.WithoutHostnameRewrite())
+	}
+	if verbose {
+		mergeOpts = append(mergeOpts, compose.WithVerbose())
+	}
+	if probePorts {
+		mergeOpts = append(mergeOpts, compose.WithPortProbe(compose.HostPortProbe{}))
+	}
+	if portStrategy != "" || portRange != "" {
+		// A range on its own selects the strategy it applies to
+		if portStrategy == "" {
+			portStrategy = compose.PortStrategyNextFree
+		}
+		strategy, err := compose.ParsePortStrategy(portStrategy, portRange)
+		if err != nil {
+			return err
+		}
+		mergeOpts = append(mergeOpts, compose.WithPortStrategy(strategy))
+	}
+	for _, value := range portBases {
+		stack, base, err := compose.ParsePortBase(value)
+		if err != nil {
+			return err
+		}
+		mergeOpts = append(mergeOpts, compose.WithPortBase(stack, base))
+	}
+	if len(profiles) == 0 {
+		// Fall back to the profiles docker compose itself would activate
+		for _, profile := range strings.Split(os.Getenv("COMPOSE_PROFILES"), ",") {
+			if profile = strings.TrimSpace(profile); profile != "" {
+				profiles = append(profiles, profile)
+			}
+		}
+	}
+	for _, value := range profiles {
+		selector, err := compose.ParseProfileSelector(value)
+		if err != nil {
+			return err
+		}
+		mergeOpts = append(mergeOpts, compose.WithProfiles(selector))
+	}
+	if prefixProfiles {
+		mergeOpts = append(mergeOpts, compose.WithPrefixedProfiles())
+	}
+	if projectName == "" {
+		projectName = os.Getenv("COMPOSE_PROJECT_NAME")
+	}
+	if projectName != "" {
+		mergeOpts = append(mergeOpts, compose.WithProjectName(projectName))
+	}
+	for _, value := range sharedNetworks {
+		name, services, _ := strings.Cut(value, "=")
+		if name == "" {
+			return fmt.Errorf("invalid shared network %q: network name is required", value)
+		}
+		var refs []string
+		if services != "" {
+			refs = strings.Split(services, ",")
+		}
+		mergeOpts = append(mergeOpts, compose.WithSharedNetwork(name, refs...))
+	}
+
 	// Merge the compose files
-	merged, err := compose.MergeComposeFiles(files)
+	merged, report, err := compose.MergeComposeFiles(files, mergeOpts...)
 	if err != nil {
 		return fmt.Errorf("error merging compose files: %v", err)
 	}
 
+	// Check the merged project without running docker compose
+	if command == "validate" || (len(args) > 0 && args[0] == "validate") {
+		if err := compose.Validate(merged, report.Sources); err != nil {
+			return err
+		}
+		fmt.Println("Merged project is valid")
+		return nil
+	}
+
 	// Create an executor with the merged configuration
-	workingDir := filepath.Dir(composeFiles[0])
-	executor := compose.NewExecutor(merged, workingDir, dryRun)
+	workingDir := filepath.Dir(composeFiles[0].Path)
+	executor := compose.NewExecutor(merged, workingDir, dryRun).WithSources(report.Sources)
+	if relativePaths {
+		executor.WithRelativePaths()
+	}
+	if noValidate {
+		executor.WithoutValidation()
+	}
 
 	// Add command-specific arguments
 	if command == "up" {
@@ -134,11 +413,27 @@ func run() error {
 
 func main() {
 	// Register flags
-	flag.Var(&composeFiles, "f", "Path to a docker-compose YAML file (can be specified multiple times)")
+	flag.Var(fileFlag{specs: &composeFiles}, "f", "Path to a docker-compose YAML file, as FILE or FILE:PREFIX (can be specified multiple times)")
+	flag.StringVar(&onCollision, "on-collision", "fail", "How to handle resource name collisions between files (fail, parent-dir)")
+	flag.Var(&prefixes, "prefix", "Prefix for a compose file's resources, as FILE=PREFIX (can be specified multiple times)")
+	flag.Var(fileFlag{specs: &composeFiles, override: true}, "override", "Compose file deep-merged into the stack given just before it (can be specified multiple times)")
+	flag.Var(&sharedNetworks, "shared-network", "Network shared across files, as NAME or NAME=STACK/SERVICE,... (can be specified multiple times)")
 	flag.BoolVar(&verbose, "verbose", false, "Enable verbose logging for detailed output")
+	flag.BoolVar(&noRewriteHosts, "no-rewrite-hosts", false, "Do not rewrite service hostnames in environment, command and healthcheck")
+	flag.StringVar(&projectName, "project-name", "", "Project name of the merged stacks (defaults to the first file's project name)")
+	flag.StringVar(&projectName, "p", "", "Project name of the merged stacks (shorthand for --project-name)")
+	flag.Var(&envFiles, "env-file", "Env file for interpolation, as ENV_FILE for every stack or FILE=ENV_FILE for one stack (can be specified multiple times)")
+	flag.Var(&profiles, "profile", "Profile to activate, as NAME or STACK:NAME (can be specified multiple times)")
+	flag.BoolVar(&prefixProfiles, "prefix-profiles", false, "Prefix profile names with their stack's prefix in the merged file")
+	flag.BoolVar(&relativePaths, "relative-paths", false, "Write paths in the merged file relative to its location")
+	flag.StringVar(&portStrategy, "port-strategy", "", "How to move conflicting ports (offset, next-free, stack-base, ephemeral)")
+	flag.StringVar(&portRange, "port-range", "", "Ports searched by the next-free strategy, as FROM-TO")
+	flag.Var(&portBases, "port-base", "Base port of a stack for the stack-base strategy, as STACK=PORT (can be specified multiple times)")
+	flag.BoolVar(&probePorts, "probe-ports", false, "Skip ports already in use on this machine when resolving port conflicts")
+	flag.BoolVar(&noValidate, "no-validate", false, "Do not validate the merged project before creating containers")
 	flag.BoolVar(&dryRun, "dry-run", false, "Simulate configuration without making runtime changes")
 	flag.BoolVar(&detach, "d", false, "Run containers in the background")
-	flag.StringVar(&command, "command", "up", "Command to execute (up, down, config, ps, logs, build, pull, push)")
+	flag.StringVar(&command, "command", "up", "Command to execute (up, down, config, env, validate, ps, logs, build, pull, push)")
 	flag.BoolVar(&showHelp, "help", false, "Show help text")
 	flag.BoolVar(&showHelp, "h", false, "Show help text")
 
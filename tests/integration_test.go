@@ -179,6 +179,9 @@ func (suite *IntegrationTestSuite) TestEndToEndDryRun() {
 	// Check for dry-run mode message
 	assert.Contains(suite.T(), outputStr, "Running in dry-run mode")
 	assert.Contains(suite.T(), outputStr, "Dry run: would execute docker compose up")
+
+	// Check that the aliases added to prefixed services are listed
+	assert.Contains(suite.T(), outputStr, "Added network alias frontend for service web_frontend on network web_default")
 }
 
 // TestEndToEndPortConflicts tests port conflict resolution
@@ -234,6 +237,118 @@ func (suite *IntegrationTestSuite) TestEndToEndPortConflicts() {
 	assert.Contains(suite.T(), outputStr, `published: "543"`)
 }
 
+// TestEndToEndPrefixes tests configurable prefixes for stacks in same-named directories
+func (suite *IntegrationTestSuite) TestEndToEndPrefixes() {
+	// Create two stacks in directories with the same name
+	var files []string
+	for _, dir := range []string{"services", "legacy"} {
+		folder := filepath.Join(suite.tmpDir, dir, "api")
+		err := os.MkdirAll(folder, 0755)
+		require.NoError(suite.T(), err)
+
+		file := filepath.Join(folder, "docker-compose.yml")
+		err = os.WriteFile(file, []byte(`services:
+  app:
+    image: nginx`), 0644)
+		require.NoError(suite.T(), err)
+		files = append(files, file)
+	}
+
+	// Without explicit prefixes both files resolve to the same prefix
+	cmd := exec.Command(suite.qecCmd,
+		"-f", files[0],
+		"-f", files[1],
+		"--command", "config",
+	)
+	output, err := cmd.CombinedOutput()
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), string(output), `both resolve to prefix "api"`)
+
+	// Prefixes given with -f FILE:PREFIX and --prefix FILE=PREFIX disambiguate them
+	cmd = exec.Command(suite.qecCmd,
+		"-f", files[0]+":api",
+		"-f", files[1],
+		"--prefix", files[1]+"=legacy_api",
+		"--command", "config",
+	)
+	output, err = cmd.CombinedOutput()
+	require.NoError(suite.T(), err, "Failed to run config command: %s", output)
+
+	outputStr := string(output)
+	assert.Contains(suite.T(), outputStr, "api_app")
+	assert.Contains(suite.T(), outputStr, "legacy_api_app")
+}
+
+// TestEndToEndProjectName tests naming the merged project with -p
+func (suite *IntegrationTestSuite) TestEndToEndProjectName() {
+	folder := filepath.Join(suite.tmpDir, "web")
+	err := os.MkdirAll(folder, 0755)
+	require.NoError(suite.T(), err)
+
+	file := filepath.Join(folder, "docker-compose.yml")
+	err = os.WriteFile(file, []byte(`services:
+  app:
+    image: nginx
+x-team: web`), 0644)
+	require.NoError(suite.T(), err)
+
+	cmd := exec.Command(suite.qecCmd,
+		"-f", file,
+		"-p", "shop",
+		"--command", "config",
+	)
+	output, err := cmd.CombinedOutput()
+	require.NoError(suite.T(), err, "Failed to run config command: %s", output)
+
+	outputStr := string(output)
+	assert.Contains(suite.T(), outputStr, "name: shop")
+	assert.Contains(suite.T(), outputStr, "x-team: web")
+}
+
+// TestEndToEndValidate tests the validate command on valid and dangling references
+func (suite *IntegrationTestSuite) TestEndToEndValidate() {
+	file1, file2 := suite.createTestFiles()
+
+	cmd := exec.Command(suite.qecCmd, "-f", file1, "-f", file2, "validate")
+	output, err := cmd.CombinedOutput()
+	require.NoError(suite.T(), err, "Failed to run validate command: %s", output)
+	assert.Contains(suite.T(), string(output), "Merged project is valid")
+
+	// A link to a service left out by its profile no longer resolves
+	folder := filepath.Join(suite.tmpDir, "debug")
+	err = os.MkdirAll(folder, 0755)
+	require.NoError(suite.T(), err)
+	file := filepath.Join(folder, "docker-compose.yml")
+	err = os.WriteFile(file, []byte(`services:
+  app:
+    image: nginx
+    links: [tools]
+  tools:
+    image: busybox
+    profiles: [debug]`), 0644)
+	require.NoError(suite.T(), err)
+
+	cmd = exec.Command(suite.qecCmd, "-f", file, "--command", "validate")
+	output, err = cmd.CombinedOutput()
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), string(output), "service debug_app ("+file+"): links refers to undefined service")
+
+	// Commands creating containers are validated before docker compose runs, unless asked not to
+	cmd = exec.Command(suite.qecCmd, "-f", file, "--dry-run", "--command", "up")
+	output, err = cmd.CombinedOutput()
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), string(output), "invalid merged project")
+
+	cmd = exec.Command(suite.qecCmd, "-f", file, "--dry-run", "--no-validate", "--command", "up")
+	output, err = cmd.CombinedOutput()
+	assert.NoError(suite.T(), err, "Failed to run up command: %s", output)
+
+	// Other commands are not validated, so the stack can still be torn down
+	cmd = exec.Command(suite.qecCmd, "-f", file, "--dry-run", "--command", "down")
+	output, err = cmd.CombinedOutput()
+	assert.NoError(suite.T(), err, "Failed to run down command: %s", output)
+}
+
 // TestEndToEndErrorHandling tests error scenarios
 func (suite *IntegrationTestSuite) TestEndToEndErrorHandling() {
 	// Test with non-existent file